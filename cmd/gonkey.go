@@ -6,18 +6,38 @@ import (
 	"log"
 	"os"
 	"os/user"
+	"strings"
 
+	"github.com/oteto/gonkey/pkg/compiler"
+	"github.com/oteto/gonkey/pkg/evaluator"
+	"github.com/oteto/gonkey/pkg/object"
+	"github.com/oteto/gonkey/pkg/optimizer"
+	"github.com/oteto/gonkey/pkg/parser"
 	"github.com/oteto/gonkey/pkg/repl"
+	"github.com/oteto/gonkey/pkg/tokenizer"
+	"github.com/oteto/gonkey/pkg/vm"
 )
 
 var (
 	tokenizerOpt = flag.Bool("t", false, "help message for \"t\" option")
 	parserOpt    = flag.Bool("p", false, "help message for \"p\" option")
 	evalOpt      = flag.Bool("e", false, "help message for \"e\" option")
+	traceOpt     = flag.Bool("trace", false, "print parser trace with \"p\" option")
+	vmOpt        = flag.Bool("vm", false, "run on the bytecode VM with \"e\" option or a script")
 )
 
 func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-t | -p | -e] [-vm]\n       %s [-vm] script [args...]\n", os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
 	flag.Parse()
+
+	// スクリプトのファイルが渡された場合は REPL を起動せずに実行する
+	if flag.NArg() > 0 {
+		os.Exit(runScript(flag.Arg(0), flag.Args()[1:]))
+	}
+
 	user, err := user.Current()
 	if err != nil {
 		log.Fatal(err)
@@ -31,11 +51,55 @@ func main() {
 		repl.TokenizerStart(os.Stdin, os.Stdout)
 	} else if *parserOpt {
 		fmt.Println("output AST.")
-		repl.ParserStart(os.Stdin, os.Stdout)
+		opts := []parser.Option{}
+		if *traceOpt {
+			opts = append(opts, parser.WithTrace(os.Stdout))
+		}
+		repl.ParserStart(os.Stdin, os.Stdout, opts...)
 	} else if *evalOpt {
 		fmt.Println("output Eval.")
-		repl.EvalStart(os.Stdin, os.Stdout)
+		if *vmOpt {
+			repl.CompileStart(os.Stdin, os.Stdout)
+		} else {
+			repl.EvalStart(os.Stdin, os.Stdout)
+		}
 	} else {
 		fmt.Println("please input option -t or -p.")
 	}
 }
+
+// 標準入出力と args を渡してスクリプトを実行し、終了コードを返す
+func runScript(path string, args []string) int {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	p := parser.New(tokenizer.New(string(src)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		fmt.Fprintf(os.Stderr, "%s: parse error: %s\n", path, strings.Join(p.Errors(), "; "))
+		return 1
+	}
+	program = optimizer.Optimize(program)
+
+	interp := evaluator.New(evaluator.WithArgs(args))
+	var result object.Object
+	if *vmOpt {
+		comp := compiler.New(compiler.WithBuiltins(interp))
+		if err := comp.Compile(program); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: compilation failed: %s\n", path, err)
+			return 1
+		}
+		result = vm.NewWithInterpreter(comp.Bytecode(), make([]object.Object, vm.GlobalsSize), interp).Run()
+	} else {
+		result = interp.Eval(program)
+	}
+
+	if err, ok := result.(*object.Error); ok {
+		fmt.Fprintf(os.Stderr, "%s: runtime error: %s\n", path, err.Message)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,233 @@
+// Go のプログラムに Gonkey を組み込むためのパッケージ
+//
+//	program, err := gonkey.Compile(`let add = fn(a, b) { a + b }; add(1, 2)`)
+//	if err != nil {
+//		// 構文エラー
+//	}
+//	value, err := gonkey.Run(ctx, program, &gonkey.Options{Timeout: time.Second})
+//	if err != nil {
+//		// 実行時エラー
+//	}
+//	fmt.Println(value.Inspect()) // 3
+package gonkey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/oteto/gonkey/pkg/ast"
+	"github.com/oteto/gonkey/pkg/evaluator"
+	"github.com/oteto/gonkey/pkg/object"
+	"github.com/oteto/gonkey/pkg/optimizer"
+	"github.com/oteto/gonkey/pkg/parser"
+	"github.com/oteto/gonkey/pkg/tokenizer"
+)
+
+// スクリプトの値
+type Value = object.Object
+
+// スクリプトに使わせるファイルシステム。DirFS か ReadOnlyFS で作る
+type FileSystem = evaluator.FileSystem
+
+// roots 以下のディレクトリだけを読み書きできる FileSystem を作る
+// 相対パスは最初のディレクトリからのパスとして扱う
+func DirFS(roots ...string) (FileSystem, error) {
+	return evaluator.DirFS(roots...)
+}
+
+// fs.FS を読み取り専用の FileSystem にする
+func ReadOnlyFS(fsys fs.FS) FileSystem {
+	return evaluator.ReadOnlyFS(fsys)
+}
+
+// スクリプトの now と sleep で使う時計。テストでは NewFakeClock で作る
+type Clock = evaluator.Clock
+
+// 実際には待たずに時刻を進める時計
+type FakeClock = evaluator.FakeClock
+
+// now から始まる FakeClock を作る
+func NewFakeClock(now time.Time) *FakeClock {
+	return evaluator.NewFakeClock(now)
+}
+
+var (
+	// 評価ステップ数の上限を超えた
+	ErrStepLimit = errors.New("gonkey: step limit exceeded")
+	// 生成したオブジェクト数の上限を超えた
+	ErrAllocationLimit = errors.New("gonkey: allocation limit exceeded")
+	// 関数呼び出しの深さの上限を超えた
+	ErrDepthLimit = errors.New("gonkey: call depth limit exceeded")
+)
+
+// 構文エラー
+type ParseError struct {
+	Errors []string
+}
+
+func (e *ParseError) Error() string {
+	return "parse error: " + strings.Join(e.Errors, "; ")
+}
+
+// 実行時エラー
+// 実行制限による場合は errors.Is で ErrStepLimit, ErrAllocationLimit, ErrDepthLimit,
+// context.DeadlineExceeded, context.Canceled と比較できる
+type RuntimeError struct {
+	Message string
+	Kind    object.ErrorKind
+}
+
+func (e *RuntimeError) Error() string {
+	return "runtime error: " + e.Message
+}
+
+func (e *RuntimeError) Unwrap() error {
+	switch e.Kind {
+	case object.STEP_LIMIT_ERROR:
+		return ErrStepLimit
+	case object.ALLOCATION_LIMIT_ERROR:
+		return ErrAllocationLimit
+	case object.DEPTH_LIMIT_ERROR:
+		return ErrDepthLimit
+	case object.TIMEOUT_ERROR:
+		return context.DeadlineExceeded
+	case object.CANCELED_ERROR:
+		return context.Canceled
+	}
+	return nil
+}
+
+// コンパイル済みのスクリプト
+// 変数の位置は Compile で解決済みで Run は AST を書き換えないので、複数の goroutine から同時に Run できる
+type Program struct {
+	program *ast.Program
+}
+
+// ソースコードを構文解析して最適化する
+func Compile(src string) (*Program, error) {
+	p := parser.New(tokenizer.New(src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, &ParseError{Errors: p.Errors()}
+	}
+
+	program = optimizer.Optimize(program)
+	evaluator.Resolve(program)
+	return &Program{program: program}, nil
+}
+
+// 0 の項目は制限なし、nil の入出力は使わない
+type Options struct {
+	Stdout io.Writer
+	Stderr io.Writer
+	Stdin  io.Reader
+
+	// 追加する組み込み関数。同じ名前の組み込み関数は置き換える
+	Builtins map[string]object.BuiltinFunction
+	// 追加する Go の関数。evaluator.WrapFunc で組み込み関数に変換する
+	Funcs map[string]interface{}
+	// 読み取り専用のグローバル変数。object.FromGo で変換する
+	Globals map[string]interface{}
+
+	MaxSteps       int
+	MaxAllocations int
+	Timeout        time.Duration
+	// 関数呼び出しの深さの上限。0 の場合は evaluator.DefaultMaxDepth を使い、無制限にはならない
+	MaxDepth int
+
+	// math.random の種。nil の場合は現在時刻を使う
+	RandomSeed *int64
+	// read_file などで使うファイルシステム。nil の場合はファイルに触れられない
+	FileSystem FileSystem
+	// スクリプトから args で参照する引数
+	Args []string
+	// now と sleep で使う時計。nil の場合は実際の時刻を使う
+	Clock Clock
+}
+
+// 新しいグローバル環境でスクリプトを実行し、最後に評価した値を返す
+// opts は nil でもよい
+func Run(ctx context.Context, program *Program, opts *Options) (Value, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	interp := evaluator.New(opts.evaluatorOptions()...)
+	for name, fn := range opts.Builtins {
+		interp.Register(name, fn)
+	}
+	for name, fn := range opts.Funcs {
+		if err := interp.RegisterFunc(name, fn); err != nil {
+			return nil, err
+		}
+	}
+	for name, v := range opts.Globals {
+		value, err := object.FromGo(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		interp.Env().SetReadOnly(name, value)
+	}
+
+	return eval(ctx, interp, program)
+}
+
+// 組み込み関数などが panic した場合も、ホストを落とさずに RuntimeError として返す
+func eval(ctx context.Context, interp *evaluator.Interpreter, program *Program) (value Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			value, err = nil, &RuntimeError{Message: fmt.Sprintf("panic: %v", r), Kind: object.RUNTIME_ERROR}
+		}
+	}()
+
+	result := interp.EvalContext(ctx, program.program)
+
+	if err, ok := result.(*object.Error); ok {
+		return nil, &RuntimeError{Message: err.Message, Kind: err.Kind}
+	}
+	if result == nil {
+		return evaluator.NULL, nil
+	}
+	return result, nil
+}
+
+func (o *Options) evaluatorOptions() []evaluator.Option {
+	stdout, stderr, stdin := o.Stdout, o.Stderr, o.Stdin
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if stderr == nil {
+		stderr = io.Discard
+	}
+	if stdin == nil {
+		stdin = strings.NewReader("")
+	}
+
+	opts := []evaluator.Option{
+		evaluator.WithStdout(stdout),
+		evaluator.WithStderr(stderr),
+		evaluator.WithStdin(stdin),
+		evaluator.WithMaxSteps(o.MaxSteps),
+		evaluator.WithMaxAllocations(o.MaxAllocations),
+		evaluator.WithMaxDepth(o.MaxDepth),
+		evaluator.WithTimeout(o.Timeout),
+	}
+	if o.Clock != nil {
+		opts = append(opts, evaluator.WithClock(o.Clock))
+	}
+	if o.Args != nil {
+		opts = append(opts, evaluator.WithArgs(o.Args))
+	}
+	if o.FileSystem != nil {
+		opts = append(opts, evaluator.WithFileSystem(o.FileSystem))
+	}
+	if o.RandomSeed != nil {
+		opts = append(opts, evaluator.WithRandomSeed(*o.RandomSeed))
+	}
+	return opts
+}
@@ -0,0 +1,365 @@
+package gonkey
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/oteto/gonkey/pkg/object"
+)
+
+func TestCompileError(t *testing.T) {
+	_, err := Compile("let = 1;")
+
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("err is not ParseError. got=%T (%v)", err, err)
+	}
+	if len(parseErr.Errors) == 0 {
+		t.Fatalf("ParseError has no errors")
+	}
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect string
+	}{
+		{"let add = fn(a, b) { a + b }; add(1, 2)", "3"},
+		{`"Hello" + " " + "World"`, "Hello World"},
+		{"let a = 1;", "null"},
+		{"[1, 2, 3] |> len", "3"},
+	}
+
+	for _, tt := range tests {
+		program, err := Compile(tt.input)
+		if err != nil {
+			t.Fatalf("%s: compile error: %s", tt.input, err)
+		}
+		value, err := Run(context.Background(), program, nil)
+		if err != nil {
+			t.Fatalf("%s: runtime error: %s", tt.input, err)
+		}
+		if value.Inspect() != tt.expect {
+			t.Errorf("%s: wrong value. want=%q, got=%q", tt.input, tt.expect, value.Inspect())
+		}
+	}
+}
+
+func TestRunError(t *testing.T) {
+	loop := "let loop = fn() { loop() }; loop()"
+	deep := "let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(10000000)"
+
+	tests := []struct {
+		input  string
+		opts   *Options
+		target error
+		kind   object.ErrorKind
+	}{
+		{"5 + true", nil, nil, object.RUNTIME_ERROR},
+		{"undefined", nil, nil, object.RUNTIME_ERROR},
+		{"1 / 0", nil, nil, object.RUNTIME_ERROR},
+		{"boom()", &Options{Builtins: map[string]object.BuiltinFunction{
+			"boom": func(args ...object.Object) object.Object { panic("boom") },
+		}}, nil, object.RUNTIME_ERROR},
+		{loop, &Options{MaxSteps: 1000}, ErrStepLimit, object.STEP_LIMIT_ERROR},
+		{loop, &Options{MaxAllocations: 1000}, ErrAllocationLimit, object.ALLOCATION_LIMIT_ERROR},
+		{loop, &Options{Timeout: 10 * time.Millisecond}, context.DeadlineExceeded, object.TIMEOUT_ERROR},
+		{deep, &Options{Timeout: 5 * time.Second}, ErrDepthLimit, object.DEPTH_LIMIT_ERROR},
+		{deep, &Options{MaxDepth: 100}, ErrDepthLimit, object.DEPTH_LIMIT_ERROR},
+	}
+
+	for _, tt := range tests {
+		program, err := Compile(tt.input)
+		if err != nil {
+			t.Fatalf("%s: compile error: %s", tt.input, err)
+		}
+
+		_, err = Run(context.Background(), program, tt.opts)
+		var runtimeErr *RuntimeError
+		if !errors.As(err, &runtimeErr) {
+			t.Fatalf("%s: err is not RuntimeError. got=%T (%v)", tt.input, err, err)
+		}
+		if runtimeErr.Kind != tt.kind {
+			t.Errorf("%s: wrong kind. want=%q, got=%q", tt.input, tt.kind, runtimeErr.Kind)
+		}
+		if tt.target != nil && !errors.Is(err, tt.target) {
+			t.Errorf("%s: err is not %v", tt.input, tt.target)
+		}
+	}
+}
+
+func TestRunWithFuncs(t *testing.T) {
+	program, err := Compile(`double(add(1, 2)) + twice`)
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+
+	opts := &Options{
+		Builtins: map[string]object.BuiltinFunction{
+			"add": func(args ...object.Object) object.Object {
+				a, b := args[0].(*object.Integer), args[1].(*object.Integer)
+				return &object.Integer{Value: a.Value + b.Value}
+			},
+		},
+		Funcs: map[string]interface{}{
+			"double": func(n int) int { return n * 2 },
+		},
+	}
+	_, err = Run(context.Background(), program, opts)
+	if err == nil {
+		t.Fatalf("undefined identifier not reported")
+	}
+
+	program, err = Compile(`double(add(1, 2))`)
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+	value, err := Run(context.Background(), program, opts)
+	if err != nil {
+		t.Fatalf("runtime error: %s", err)
+	}
+	if value.Inspect() != "6" {
+		t.Errorf("wrong value. want=%q, got=%q", "6", value.Inspect())
+	}
+
+	opts.Funcs["bad"] = 1
+	if _, err := Run(context.Background(), program, opts); err == nil {
+		t.Errorf("unsupported func not reported")
+	}
+}
+
+func TestRunWithGlobals(t *testing.T) {
+	opts := &Options{
+		Globals: map[string]interface{}{
+			"request": map[string]interface{}{"path": "/", "ids": []int{1, 2}},
+			"debug":   true,
+		},
+	}
+
+	tests := []struct {
+		input  string
+		expect string
+		err    string
+	}{
+		{`if (debug) { request["path"] + len(request["ids"]) }`, "", "runtime error: type mismatch: STRING + INTEGER"},
+		{`request["ids"][1]`, "2", ""},
+		{`let debug = false;`, "", "runtime error: cannot assign to read-only variable: debug"},
+		{`let f = fn(request) { request }; f(1)`, "", "runtime error: cannot assign to read-only variable: request"},
+	}
+
+	for _, tt := range tests {
+		program, err := Compile(tt.input)
+		if err != nil {
+			t.Fatalf("%s: compile error: %s", tt.input, err)
+		}
+		value, err := Run(context.Background(), program, opts)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: wrong error. want=%q, got=%v", tt.input, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: runtime error: %s", tt.input, err)
+		}
+		if value.Inspect() != tt.expect {
+			t.Errorf("%s: wrong value. want=%q, got=%q", tt.input, tt.expect, value.Inspect())
+		}
+	}
+
+	program, _ := Compile("1")
+	_, err := Run(context.Background(), program, &Options{Globals: map[string]interface{}{"f": 1.5}})
+	if err == nil {
+		t.Errorf("unsupported global not reported")
+	}
+
+	type node struct{ Next *node }
+	cyclic := &node{}
+	cyclic.Next = cyclic
+	_, err = Run(context.Background(), program, &Options{Globals: map[string]interface{}{"n": cyclic}})
+	if err == nil || !strings.Contains(err.Error(), "cyclic value") {
+		t.Errorf("cyclic global not reported. got=%v", err)
+	}
+}
+
+func TestRunWithRandomSeed(t *testing.T) {
+	program, err := Compile(`[math.random(1000), math.random(1000), math.random(1000)]`)
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+	run := func(seed int64) string {
+		value, err := Run(context.Background(), program, &Options{RandomSeed: &seed})
+		if err != nil {
+			t.Fatalf("runtime error: %s", err)
+		}
+		return value.Inspect()
+	}
+
+	// 0 も現在時刻ではなく種として使う
+	for _, seed := range []int64{0, 7} {
+		if first, second := run(seed), run(seed); first != second {
+			t.Errorf("seed %d produced different results: %q, %q", seed, first, second)
+		}
+	}
+	if run(0) == run(7) {
+		t.Errorf("different seeds produced the same results: %q", run(0))
+	}
+}
+
+func TestRunWithFileSystem(t *testing.T) {
+	program, err := Compile(`read_file("greeting.txt")`)
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+
+	if _, err := Run(context.Background(), program, nil); err == nil {
+		t.Errorf("file system was accessible without being granted")
+	}
+
+	fsys := ReadOnlyFS(fstest.MapFS{"greeting.txt": {Data: []byte("hello")}})
+	value, err := Run(context.Background(), program, &Options{FileSystem: fsys})
+	if err != nil {
+		t.Fatalf("runtime error: %s", err)
+	}
+	if value.Inspect() != "hello" {
+		t.Errorf("wrong value. want=%q, got=%q", "hello", value.Inspect())
+	}
+}
+
+func TestRunWithDirFS(t *testing.T) {
+	dir := t.TempDir()
+	fsys, err := DirFS(dir)
+	if err != nil {
+		t.Fatalf("DirFS error: %s", err)
+	}
+
+	program, err := Compile(`write_file("out.txt", "hello"); read_file("out.txt")`)
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+	value, err := Run(context.Background(), program, &Options{FileSystem: fsys})
+	if err != nil {
+		t.Fatalf("runtime error: %s", err)
+	}
+	if value.Inspect() != "hello" {
+		t.Errorf("wrong value. want=%q, got=%q", "hello", value.Inspect())
+	}
+
+	program, _ = Compile(`read_file("../outside.txt")`)
+	if _, err := Run(context.Background(), program, &Options{FileSystem: fsys}); err == nil {
+		t.Errorf("path outside the directory was accessible")
+	}
+}
+
+func TestRunWithArgsAndStdin(t *testing.T) {
+	program, err := Compile(`let name = read_line(); format("%s %s", args[0], name)`)
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+	value, err := Run(context.Background(), program, &Options{
+		Args:  []string{"hello"},
+		Stdin: strings.NewReader("gonkey\n"),
+	})
+	if err != nil {
+		t.Fatalf("runtime error: %s", err)
+	}
+	if value.Inspect() != "hello gonkey" {
+		t.Errorf("wrong value. want=%q, got=%q", "hello gonkey", value.Inspect())
+	}
+}
+
+func TestRunWithStderr(t *testing.T) {
+	program, err := Compile(`puts("out"); eputs("err")`)
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+	var stdout, stderr bytes.Buffer
+	if _, err := Run(context.Background(), program, &Options{Stdout: &stdout, Stderr: &stderr}); err != nil {
+		t.Fatalf("runtime error: %s", err)
+	}
+	if stdout.String() != "out\n" || stderr.String() != "err\n" {
+		t.Errorf("wrong output. stdout=%q, stderr=%q", stdout.String(), stderr.String())
+	}
+}
+
+func TestRunWithClock(t *testing.T) {
+	program, err := Compile(`let start = now(); sleep(duration("1h")); format_duration(now() - start)`)
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	value, err := Run(context.Background(), program, &Options{Clock: clock, Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("runtime error: %s", err)
+	}
+	if value.Inspect() != "1h0m0s" {
+		t.Errorf("wrong value. want=%q, got=%q", "1h0m0s", value.Inspect())
+	}
+	if !clock.Now().Equal(start.Add(time.Hour)) {
+		t.Errorf("clock was not advanced. got=%s", clock.Now())
+	}
+
+	_, err = Run(context.Background(), program, &Options{Timeout: 10 * time.Millisecond})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("sleep did not stop at the timeout. got=%v", err)
+	}
+}
+
+func TestRunConcurrently(t *testing.T) {
+	program, err := Compile(`let f = fn(n) { if (n > 0) { puts(n); f(n - 1) } }; f(50)`)
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+
+	var expect bytes.Buffer
+	for n := 50; n > 0; n-- {
+		fmt.Fprintf(&expect, "%d\n", n)
+	}
+
+	const n = 8
+	var wg sync.WaitGroup
+	outputs := make([]bytes.Buffer, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			Run(context.Background(), program, &Options{Stdout: &outputs[i]})
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range outputs {
+		if outputs[i].String() != expect.String() {
+			t.Errorf("output %d wrong. got=%q", i, outputs[i].String())
+		}
+	}
+}
+
+func ExampleRun() {
+	program, err := Compile(`let greet = fn(name) { "Hello, " + name }; puts(greet("Gonkey")); 60 * 60`)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	var stdout bytes.Buffer
+	value, err := Run(context.Background(), program, &Options{Stdout: &stdout, Timeout: time.Second})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Print(stdout.String())
+	fmt.Println(value.Inspect())
+	// Output:
+	// Hello, Gonkey
+	// 3600
+}
@@ -25,6 +25,7 @@ type Expression interface {
 
 type Program struct {
 	Statements []Statement
+	Resolved   bool // 変数の位置と末尾呼び出しが解決済み（resolver が設定する）
 }
 
 func (p *Program) String() string {
@@ -156,6 +157,13 @@ func (rs *ReturnStatement) MarshalJSON() ([]byte, error) {
 type Identifer struct {
 	Token token.Token // IDENT
 	Value string
+
+	// 以下は evaluator の resolver が設定する
+	// Local が true の場合、Depth 個外側の関数スコープの Slot 番目のローカル変数を指す
+	// false の場合はグローバル変数か組み込み関数として名前で探す
+	Local bool
+	Depth int
+	Slot  int
 }
 
 func (i *Identifer) expressionNode() {}
@@ -379,6 +387,7 @@ type FunctionLiteral struct {
 	Token      token.Token
 	Patameters []*Identifer
 	Body       *BlockStatement
+	NumLocals  int // 引数を含むローカル変数の数（resolver が設定する）
 }
 
 func (f *FunctionLiteral) expressionNode() {}
@@ -420,6 +429,7 @@ type CallExpression struct {
 	Token     token.Token
 	Function  Expression
 	Arguments []Expression
+	Tail      bool // 関数の末尾位置の呼び出し（resolver が設定する）
 }
 
 func (ce *CallExpression) expressionNode() {}
@@ -552,9 +562,91 @@ func (ie *IndexExpression) MarshalJSON() ([]byte, error) {
 	})
 }
 
+type SliceExpression struct {
+	Token token.Token // [
+	Left  Expression
+	Low   Expression // 省略された場合は nil
+	High  Expression // 省略された場合は nil
+}
+
+func (se *SliceExpression) expressionNode() {}
+
+func (se *SliceExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(se.Left.String())
+	out.WriteString("[")
+	if se.Low != nil {
+		out.WriteString(se.Low.String())
+	}
+	out.WriteString(":")
+	if se.High != nil {
+		out.WriteString(se.High.String())
+	}
+	out.WriteString("])")
+
+	return out.String()
+}
+
+func (se *SliceExpression) TokenLiteral() string {
+	return se.Token.Literal
+}
+
+func (se *SliceExpression) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		Type string
+		Left Expression
+		Low  Expression
+		High Expression
+	}{
+		Type: "SliceExpressionNode",
+		Left: se.Left,
+		Low:  se.Low,
+		High: se.High,
+	})
+}
+
+type PropertyExpression struct {
+	Token    token.Token // .
+	Left     Expression
+	Property *Identifer
+}
+
+func (pe *PropertyExpression) expressionNode() {}
+
+func (pe *PropertyExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(pe.Left.String())
+	out.WriteString(".")
+	out.WriteString(pe.Property.String())
+	out.WriteString(")")
+
+	return out.String()
+}
+
+func (pe *PropertyExpression) TokenLiteral() string {
+	return pe.Token.Literal
+}
+
+func (pe *PropertyExpression) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		Type     string
+		Left     Expression
+		Property *Identifer
+	}{
+		Type:     "PropertyExpressionNode",
+		Left:     pe.Left,
+		Property: pe.Property,
+	})
+}
+
 type HashLiteral struct {
 	Token token.Token
 	Pairs map[Expression]Expression
+	Keys  []Expression // ソースコード上に現れた順のキー
 }
 
 func (hl *HashLiteral) expressionNode() {}
@@ -562,11 +654,9 @@ func (hl *HashLiteral) expressionNode() {}
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 
-	pairs := make([]string, len(hl.Pairs))
-	i := 0
-	for k, v := range hl.Pairs {
-		pairs[i] = k.String() + ":" + v.String()
-		i++
+	pairs := make([]string, 0, len(hl.Pairs))
+	for _, k := range hl.OrderedKeys() {
+		pairs = append(pairs, k.String()+":"+hl.Pairs[k].String())
 	}
 
 	out.WriteString("{")
@@ -585,11 +675,9 @@ func (hl *HashLiteral) MarshalJSON() ([]byte, error) {
 		Key   Expression
 		Value Expression
 	}
-	pairs := make([]Pair, len(hl.Pairs))
-	i := 0
-	for k, v := range hl.Pairs {
-		pairs[i] = Pair{Key: k, Value: v}
-		i++
+	pairs := make([]Pair, 0, len(hl.Pairs))
+	for _, k := range hl.OrderedKeys() {
+		pairs = append(pairs, Pair{Key: k, Value: hl.Pairs[k]})
 	}
 
 	return json.Marshal(&struct {
@@ -600,3 +688,16 @@ func (hl *HashLiteral) MarshalJSON() ([]byte, error) {
 		Pairs: pairs,
 	})
 }
+
+// キーをソースコード上の順で返す
+// Keys を持たない（パーサを経由せずに作られた）場合は Pairs の順で返す
+func (hl *HashLiteral) OrderedKeys() []Expression {
+	if len(hl.Keys) == len(hl.Pairs) {
+		return hl.Keys
+	}
+	keys := make([]Expression, 0, len(hl.Pairs))
+	for k := range hl.Pairs {
+		keys = append(keys, k)
+	}
+	return keys
+}
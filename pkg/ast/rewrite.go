@@ -0,0 +1,156 @@
+package ast
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Rewrite で各ノードに適用される関数
+// 置き換え後のノードを返す。置き換えない場合は受け取ったノードをそのまま返す
+type RewriteFunc func(Node) Node
+
+// AST を深さ優先で走査し、ノードを置き換える（golang.org/x/tools/go/ast/astutil.Apply の post と同様）
+// 子ノードを書き換えたあとで親ノードに fn を適用するので、fn は書き換え済みの子ノードを受け取る
+// 文のリスト（Program, BlockStatement）の中で fn が nil を返すとその文は取り除かれる
+// 式を置き換える場合は Expression を、文を置き換える場合は Statement を返す必要があり、
+// 置き換えられないノードを返すと panic する
+func Rewrite(node Node, fn RewriteFunc) Node {
+	if isNilNode(node) {
+		return node
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		n.Statements = rewriteStatements(n.Statements, fn)
+	case *ExpressionStatement:
+		n.Expression = rewriteExpression(n, n.Expression, fn)
+	case *LetStatement:
+		if n.Name != nil {
+			n.Name = rewriteIdentifier(n, n.Name, fn)
+		}
+		n.Value = rewriteExpression(n, n.Value, fn)
+	case *ReturnStatement:
+		n.ReturnValue = rewriteExpression(n, n.ReturnValue, fn)
+	case *BlockStatement:
+		n.Statements = rewriteStatements(n.Statements, fn)
+	case *PrefixExpression:
+		n.Right = rewriteExpression(n, n.Right, fn)
+	case *InfixExpression:
+		n.Left = rewriteExpression(n, n.Left, fn)
+		n.Right = rewriteExpression(n, n.Right, fn)
+	case *IfExpression:
+		n.Condition = rewriteExpression(n, n.Condition, fn)
+		if n.Consequence != nil {
+			n.Consequence = rewriteBlock(n, n.Consequence, fn)
+		}
+		if n.Alternative != nil {
+			n.Alternative = rewriteBlock(n, n.Alternative, fn)
+		}
+	case *FunctionLiteral:
+		for i, param := range n.Patameters {
+			if param != nil {
+				n.Patameters[i] = rewriteIdentifier(n, param, fn)
+			}
+		}
+		if n.Body != nil {
+			n.Body = rewriteBlock(n, n.Body, fn)
+		}
+	case *CallExpression:
+		n.Function = rewriteExpression(n, n.Function, fn)
+		n.Arguments = rewriteExpressions(n, n.Arguments, fn)
+	case *ArrayLiteral:
+		n.Elements = rewriteExpressions(n, n.Elements, fn)
+	case *IndexExpression:
+		n.Left = rewriteExpression(n, n.Left, fn)
+		n.Index = rewriteExpression(n, n.Index, fn)
+	case *SliceExpression:
+		n.Left = rewriteExpression(n, n.Left, fn)
+		n.Low = rewriteExpression(n, n.Low, fn)
+		n.High = rewriteExpression(n, n.High, fn)
+	case *PropertyExpression:
+		n.Left = rewriteExpression(n, n.Left, fn)
+		if n.Property != nil {
+			n.Property = rewriteIdentifier(n, n.Property, fn)
+		}
+	case *HashLiteral:
+		keys := n.OrderedKeys()
+		pairs := make(map[Expression]Expression, len(n.Pairs))
+		newKeys := make([]Expression, 0, len(keys))
+		for _, key := range keys {
+			value := rewriteExpression(n, n.Pairs[key], fn)
+			key = rewriteExpression(n, key, fn)
+			pairs[key] = value
+			newKeys = append(newKeys, key)
+		}
+		n.Pairs = pairs
+		n.Keys = newKeys
+	}
+
+	return fn(node)
+}
+
+func rewriteStatements(stmts []Statement, fn RewriteFunc) []Statement {
+	result := make([]Statement, 0, len(stmts))
+	for _, stmt := range stmts {
+		replaced := Rewrite(stmt, fn)
+		if isNilNode(replaced) && !isNilNode(stmt) {
+			continue
+		}
+		s, ok := replaced.(Statement)
+		if !ok {
+			panic(fmt.Sprintf("ast.Rewrite: cannot replace statement %T with %T", stmt, replaced))
+		}
+		result = append(result, s)
+	}
+	return result
+}
+
+func rewriteExpressions(parent Node, exps []Expression, fn RewriteFunc) []Expression {
+	if exps == nil {
+		return nil
+	}
+	for i, exp := range exps {
+		exps[i] = rewriteExpression(parent, exp, fn)
+	}
+	return exps
+}
+
+func rewriteExpression(parent Node, exp Expression, fn RewriteFunc) Expression {
+	if exp == nil {
+		return nil
+	}
+	replaced := Rewrite(exp, fn)
+	e, ok := replaced.(Expression)
+	if !ok {
+		panic(fmt.Sprintf("ast.Rewrite: cannot replace %T in %T with %T", exp, parent, replaced))
+	}
+	return e
+}
+
+func rewriteIdentifier(parent Node, ident *Identifer, fn RewriteFunc) *Identifer {
+	replaced := Rewrite(ident, fn)
+	i, ok := replaced.(*Identifer)
+	if !ok {
+		panic(fmt.Sprintf("ast.Rewrite: cannot replace %T in %T with %T", ident, parent, replaced))
+	}
+	return i
+}
+
+func rewriteBlock(parent Node, block *BlockStatement, fn RewriteFunc) *BlockStatement {
+	replaced := Rewrite(block, fn)
+	b, ok := replaced.(*BlockStatement)
+	if !ok {
+		panic(fmt.Sprintf("ast.Rewrite: cannot replace %T in %T with %T", block, parent, replaced))
+	}
+	return b
+}
+
+// nil もしくは nil ポインタを持つノードかどうか
+// パースに失敗した文は型付きの nil になることがある
+func isNilNode(node Node) bool {
+	if node == nil {
+		return true
+	}
+	v := reflect.ValueOf(node)
+	return v.Kind() == reflect.Pointer && v.IsNil()
+}
@@ -0,0 +1,117 @@
+package ast
+
+// Walk で各ノードを訪れるたびに呼ばれる
+// 戻り値の Visitor で子ノードを訪れ、nil を返すと子ノードは訪れない
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// AST を深さ優先で走査する（go/ast.Walk と同じ振る舞い）
+// node に対して v.Visit(node) を呼び、戻り値 w が nil でなければ
+// 子ノードそれぞれを w で走査したあと w.Visit(nil) を呼ぶ
+func Walk(v Visitor, node Node) {
+	if isNilNode(node) {
+		return
+	}
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		walkStatements(v, n.Statements)
+	case *ExpressionStatement:
+		walkExpression(v, n.Expression)
+	case *LetStatement:
+		if n.Name != nil {
+			Walk(v, n.Name)
+		}
+		walkExpression(v, n.Value)
+	case *ReturnStatement:
+		walkExpression(v, n.ReturnValue)
+	case *BlockStatement:
+		walkStatements(v, n.Statements)
+	case *PrefixExpression:
+		walkExpression(v, n.Right)
+	case *InfixExpression:
+		walkExpression(v, n.Left)
+		walkExpression(v, n.Right)
+	case *IfExpression:
+		walkExpression(v, n.Condition)
+		if n.Consequence != nil {
+			Walk(v, n.Consequence)
+		}
+		if n.Alternative != nil {
+			Walk(v, n.Alternative)
+		}
+	case *FunctionLiteral:
+		for _, param := range n.Patameters {
+			if param != nil {
+				Walk(v, param)
+			}
+		}
+		if n.Body != nil {
+			Walk(v, n.Body)
+		}
+	case *CallExpression:
+		walkExpression(v, n.Function)
+		walkExpressions(v, n.Arguments)
+	case *ArrayLiteral:
+		walkExpressions(v, n.Elements)
+	case *IndexExpression:
+		walkExpression(v, n.Left)
+		walkExpression(v, n.Index)
+	case *SliceExpression:
+		walkExpression(v, n.Left)
+		walkExpression(v, n.Low)
+		walkExpression(v, n.High)
+	case *PropertyExpression:
+		walkExpression(v, n.Left)
+		if n.Property != nil {
+			Walk(v, n.Property)
+		}
+	case *HashLiteral:
+		for _, key := range n.OrderedKeys() {
+			walkExpression(v, key)
+			walkExpression(v, n.Pairs[key])
+		}
+	case *Identifer, *IntegerLiteral, *Boolean, *StringLiteral:
+		// 子ノードを持たない
+	}
+
+	v.Visit(nil)
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// AST を深さ優先で走査し、各ノードで f(node) を呼ぶ（go/ast.Inspect と同じ振る舞い）
+// f が false を返すとそのノードの子ノードは訪れない
+// 子ノードを訪れ終わると f(nil) が呼ばれる
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
+
+func walkStatements(v Visitor, stmts []Statement) {
+	for _, stmt := range stmts {
+		Walk(v, stmt)
+	}
+}
+
+func walkExpressions(v Visitor, exps []Expression) {
+	for _, exp := range exps {
+		walkExpression(v, exp)
+	}
+}
+
+func walkExpression(v Visitor, exp Expression) {
+	if exp != nil {
+		Walk(v, exp)
+	}
+}
@@ -0,0 +1,228 @@
+package ast
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/oteto/gonkey/pkg/token"
+)
+
+// let f = fn(x, y) { if (x < y) { return {"a": x}; } else { [y, -1] } }; f(1, 2)["a"];
+func newTestProgram() *Program {
+	x := &Identifer{Token: token.Token{Type: token.IDENT, Literal: "x"}, Value: "x"}
+	y := &Identifer{Token: token.Token{Type: token.IDENT, Literal: "y"}, Value: "y"}
+	key := &StringLiteral{Token: token.Token{Type: token.STRING, Literal: "a"}, Value: "a"}
+
+	return &Program{Statements: []Statement{
+		&LetStatement{
+			Token: token.Token{Type: token.LET, Literal: "let"},
+			Name:  &Identifer{Token: token.Token{Type: token.IDENT, Literal: "f"}, Value: "f"},
+			Value: &FunctionLiteral{
+				Token:      token.Token{Type: token.FUNCTION, Literal: "fn"},
+				Patameters: []*Identifer{x, y},
+				Body: &BlockStatement{Statements: []Statement{
+					&ExpressionStatement{Expression: &IfExpression{
+						Condition: &InfixExpression{Left: x, Operator: "<", Right: y},
+						Consequence: &BlockStatement{Statements: []Statement{
+							&ReturnStatement{Token: token.Token{Type: token.RETURN, Literal: "return"}, ReturnValue: &HashLiteral{
+								Pairs: map[Expression]Expression{key: x},
+								Keys:  []Expression{key},
+							}},
+						}},
+						Alternative: &BlockStatement{Statements: []Statement{
+							&ExpressionStatement{Expression: &ArrayLiteral{Elements: []Expression{
+								y,
+								&PrefixExpression{Operator: "-", Right: &IntegerLiteral{Token: token.Token{Type: token.INT, Literal: "1"}, Value: 1}},
+							}}},
+						}},
+					}},
+				}},
+			},
+		},
+		&ExpressionStatement{Expression: &IndexExpression{
+			Left: &CallExpression{
+				Function: &Identifer{Token: token.Token{Type: token.IDENT, Literal: "f"}, Value: "f"},
+				Arguments: []Expression{
+					&IntegerLiteral{Token: token.Token{Type: token.INT, Literal: "1"}, Value: 1},
+					&IntegerLiteral{Token: token.Token{Type: token.INT, Literal: "2"}, Value: 2},
+				},
+			},
+			Index: &StringLiteral{Token: token.Token{Type: token.STRING, Literal: "a"}, Value: "a"},
+		}},
+	}}
+}
+
+func TestInspect(t *testing.T) {
+	expected := []string{
+		"*ast.Program",
+		"*ast.LetStatement",
+		"*ast.Identifer f",
+		"*ast.FunctionLiteral",
+		"*ast.Identifer x",
+		"*ast.Identifer y",
+		"*ast.BlockStatement",
+		"*ast.ExpressionStatement",
+		"*ast.IfExpression",
+		"*ast.InfixExpression",
+		"*ast.Identifer x",
+		"*ast.Identifer y",
+		"*ast.BlockStatement",
+		"*ast.ReturnStatement",
+		"*ast.HashLiteral",
+		"*ast.StringLiteral",
+		"*ast.Identifer x",
+		"*ast.BlockStatement",
+		"*ast.ExpressionStatement",
+		"*ast.ArrayLiteral",
+		"*ast.Identifer y",
+		"*ast.PrefixExpression",
+		"*ast.IntegerLiteral",
+		"*ast.ExpressionStatement",
+		"*ast.IndexExpression",
+		"*ast.CallExpression",
+		"*ast.Identifer f",
+		"*ast.IntegerLiteral",
+		"*ast.IntegerLiteral",
+		"*ast.StringLiteral",
+	}
+
+	visited := []string{}
+	Inspect(newTestProgram(), func(n Node) bool {
+		if n == nil {
+			return false
+		}
+		if ident, ok := n.(*Identifer); ok {
+			visited = append(visited, fmt.Sprintf("%T %s", n, ident.Value))
+		} else {
+			visited = append(visited, fmt.Sprintf("%T", n))
+		}
+		return true
+	})
+
+	if len(visited) != len(expected) {
+		t.Fatalf("wrong number of visited nodes. want=%d, got=%d (%v)", len(expected), len(visited), visited)
+	}
+	for i, e := range expected {
+		if visited[i] != e {
+			t.Errorf("visited[%d] wrong. want=%q, got=%q", i, e, visited[i])
+		}
+	}
+}
+
+func TestInspectSkipChildren(t *testing.T) {
+	count := 0
+	Inspect(newTestProgram(), func(n Node) bool {
+		if n == nil {
+			return false
+		}
+		count++
+		_, isFunction := n.(*FunctionLiteral)
+		return !isFunction
+	})
+
+	// FunctionLiteral の子ノード（19 個）は訪れない
+	if count != 11 {
+		t.Fatalf("wrong number of visited nodes. want=%d, got=%d", 11, count)
+	}
+}
+
+type countVisitor struct {
+	enter, leave int
+}
+
+func (c *countVisitor) Visit(n Node) Visitor {
+	if n == nil {
+		c.leave++
+		return nil
+	}
+	c.enter++
+	return c
+}
+
+func TestWalkVisitsNilAfterChildren(t *testing.T) {
+	v := &countVisitor{}
+	Walk(v, newTestProgram())
+
+	if v.enter != 30 {
+		t.Fatalf("wrong number of entered nodes. want=%d, got=%d", 30, v.enter)
+	}
+	if v.leave != v.enter {
+		t.Fatalf("Visit(nil) count does not match. want=%d, got=%d", v.enter, v.leave)
+	}
+}
+
+func TestWalkSkipsNilStatement(t *testing.T) {
+	var nilLet *LetStatement
+	program := &Program{Statements: []Statement{nilLet}}
+
+	v := &countVisitor{}
+	Walk(v, program)
+	if v.enter != 1 {
+		t.Fatalf("wrong number of entered nodes. want=%d, got=%d", 1, v.enter)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	program := newTestProgram()
+
+	// 識別子 x を z に、整数リテラルを 2 倍にする
+	result := Rewrite(program, func(n Node) Node {
+		switch n := n.(type) {
+		case *Identifer:
+			if n.Value == "x" {
+				return &Identifer{Token: token.Token{Type: token.IDENT, Literal: "z"}, Value: "z"}
+			}
+		case *IntegerLiteral:
+			v := n.Value * 2
+			return &IntegerLiteral{Token: token.Token{Type: token.INT, Literal: fmt.Sprintf("%d", v)}, Value: v}
+		case *StringLiteral:
+			return &StringLiteral{Token: token.Token{Type: token.STRING, Literal: n.Value + n.Value}, Value: n.Value + n.Value}
+		}
+		return n
+	})
+
+	expected := `let f = fn(z, y)if(z < y) return {aa:z};else [y, (-2)];(f(2, 4)[aa])`
+	if result.String() != expected {
+		t.Fatalf("wrong rewritten program. want=%q, got=%q", expected, result.String())
+	}
+
+	hash := program.Statements[0].(*LetStatement).Value.(*FunctionLiteral).
+		Body.Statements[0].(*ExpressionStatement).Expression.(*IfExpression).
+		Consequence.Statements[0].(*ReturnStatement).ReturnValue.(*HashLiteral)
+	if len(hash.Keys) != 1 || len(hash.Pairs) != 1 {
+		t.Fatalf("wrong hash pairs. keys=%d, pairs=%d", len(hash.Keys), len(hash.Pairs))
+	}
+	if _, ok := hash.Pairs[hash.Keys[0]]; !ok {
+		t.Fatalf("hash.Keys and hash.Pairs are out of sync")
+	}
+}
+
+func TestRewriteRemovesStatement(t *testing.T) {
+	program := newTestProgram()
+
+	Rewrite(program, func(n Node) Node {
+		if _, ok := n.(*LetStatement); ok {
+			return nil
+		}
+		return n
+	})
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("statement is not removed. got=%d", len(program.Statements))
+	}
+}
+
+func TestRewritePanicsOnInvalidReplacement(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Fatalf("Rewrite did not panic")
+		}
+	}()
+
+	Rewrite(newTestProgram(), func(n Node) Node {
+		if _, ok := n.(*InfixExpression); ok {
+			return &BlockStatement{}
+		}
+		return n
+	})
+}
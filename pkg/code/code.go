@@ -0,0 +1,258 @@
+package code
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// バイトコードの命令列
+type Instructions []byte
+
+func (ins Instructions) String() string {
+	var out bytes.Buffer
+
+	i := 0
+	for i < len(ins) {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i++
+			continue
+		}
+
+		operands, read := ReadOperands(def, ins[i+1:])
+		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+		i += 1 + read
+	}
+
+	return out.String()
+}
+
+func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
+	operandCount := len(def.OperandWidths)
+	if len(operands) != operandCount {
+		return fmt.Sprintf("ERROR: operand len %d does not match defined %d\n", len(operands), operandCount)
+	}
+
+	switch operandCount {
+	case 0:
+		return def.Name
+	case 1:
+		return fmt.Sprintf("%s %d", def.Name, operands[0])
+	case 2:
+		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
+	case 3:
+		return fmt.Sprintf("%s %d %d %d", def.Name, operands[0], operands[1], operands[2])
+	}
+
+	return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", def.Name)
+}
+
+type Opcode byte
+
+const (
+	OpConstant Opcode = iota
+	OpPop
+
+	// 演算子
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpEqual
+	OpNotEqual
+	OpGreaterThan
+	OpLessThan
+	OpMinus
+	OpBang
+
+	OpTrue
+	OpFalse
+	OpNull
+
+	// 分岐
+	OpJumpNotTruthy
+	OpJump
+
+	// 変数
+	OpGetGlobal
+	OpSetGlobal
+	OpGetLocal
+	OpSetLocal
+	OpGetBuiltin
+	OpGetFree
+	OpCurrentClosure
+	OpMakeCell
+	OpGetCell
+	OpSetCell
+	OpGetFreeCell
+
+	// データ構造
+	OpArray
+	OpHash
+	OpIndex
+	OpSlice
+	OpProperty
+
+	// 関数
+	OpCall
+	OpTailCall
+	OpMethod
+	OpReturnValue
+	OpReturn
+	OpClosure
+
+	// 引数の評価で発生した実行時エラーを捕捉する
+	OpCatch
+	OpCatchMethod
+	OpCatchReceiver
+	OpEndCatch
+)
+
+// スライスの境界が指定されているかを表す OpSlice のオペランド
+const (
+	SliceLow  = 1 << 0
+	SliceHigh = 1 << 1
+)
+
+type Definition struct {
+	Name          string
+	OperandWidths []int // 各オペランドのバイト数
+}
+
+var definitions = map[Opcode]*Definition{
+	OpConstant: {"OpConstant", []int{2}},
+	OpPop:      {"OpPop", []int{}},
+
+	OpAdd:         {"OpAdd", []int{}},
+	OpSub:         {"OpSub", []int{}},
+	OpMul:         {"OpMul", []int{}},
+	OpDiv:         {"OpDiv", []int{}},
+	OpEqual:       {"OpEqual", []int{}},
+	OpNotEqual:    {"OpNotEqual", []int{}},
+	OpGreaterThan: {"OpGreaterThan", []int{}},
+	OpLessThan:    {"OpLessThan", []int{}},
+	OpMinus:       {"OpMinus", []int{}},
+	OpBang:        {"OpBang", []int{}},
+
+	OpTrue:  {"OpTrue", []int{}},
+	OpFalse: {"OpFalse", []int{}},
+	OpNull:  {"OpNull", []int{}},
+
+	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}},
+	OpJump:          {"OpJump", []int{2}},
+
+	OpGetGlobal:      {"OpGetGlobal", []int{2}},
+	OpSetGlobal:      {"OpSetGlobal", []int{2}},
+	OpGetLocal:       {"OpGetLocal", []int{1}},
+	OpSetLocal:       {"OpSetLocal", []int{1}},
+	OpGetBuiltin:     {"OpGetBuiltin", []int{2}}, // 組み込み関数名の定数インデックス
+	OpGetFree:        {"OpGetFree", []int{1}},
+	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+	OpMakeCell:       {"OpMakeCell", []int{1}}, // ローカル変数の値を Cell に入れる
+	OpGetCell:        {"OpGetCell", []int{1}},
+	OpSetCell:        {"OpSetCell", []int{1}},
+	OpGetFreeCell:    {"OpGetFreeCell", []int{1}},
+
+	OpArray:    {"OpArray", []int{2}},
+	OpHash:     {"OpHash", []int{2}},
+	OpIndex:    {"OpIndex", []int{}},
+	OpSlice:    {"OpSlice", []int{1}},    // SliceLow | SliceHigh
+	OpProperty: {"OpProperty", []int{2}}, // プロパティ名の定数インデックス
+
+	OpCall:        {"OpCall", []int{1}},      // 引数の数
+	OpTailCall:    {"OpTailCall", []int{1}},  // 呼び出し元のフレームを再利用する OpCall
+	OpMethod:      {"OpMethod", []int{2, 1}}, // メソッド名の定数インデックス, 引数の数
+	OpReturnValue: {"OpReturnValue", []int{}},
+	OpReturn:      {"OpReturn", []int{}},
+	OpClosure:     {"OpClosure", []int{2, 1}}, // 関数の定数インデックス, 自由変数の数
+
+	OpCatch:         {"OpCatch", []int{2, 1}},          // 捕捉後に続ける位置, 何番目の引数か
+	OpCatchMethod:   {"OpCatchMethod", []int{2, 2, 1}}, // 捕捉後に続ける位置, メソッド名の定数インデックス, 何番目の引数か
+	OpCatchReceiver: {"OpCatchReceiver", []int{2, 2}},  // 捕捉後に続ける位置, メソッド名の定数インデックス
+	OpEndCatch:      {"OpEndCatch", []int{}},
+}
+
+func Lookup(op byte) (*Definition, error) {
+	def, ok := definitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+	return def, nil
+}
+
+// 命令を作成する
+func Make(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+
+	instructionLen := 1
+	for _, w := range def.OperandWidths {
+		instructionLen += w
+	}
+
+	instruction := make([]byte, instructionLen)
+	instruction[0] = byte(op)
+
+	offset := 1
+	for i, o := range operands {
+		width := def.OperandWidths[i]
+		switch width {
+		case 2:
+			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 1:
+			instruction[offset] = byte(o)
+		}
+		offset += width
+	}
+
+	return instruction
+}
+
+// オペランドが定義されたバイト数に収まるかを検査する
+// Make は収まらないオペランドを切り詰めるので、命令を作る前に確認する
+func CheckOperands(op Opcode, operands ...int) error {
+	def, err := Lookup(byte(op))
+	if err != nil {
+		return err
+	}
+	for i, o := range operands {
+		if i >= len(def.OperandWidths) {
+			return fmt.Errorf("too many operands for %s: %d", def.Name, len(operands))
+		}
+		max := 1<<(8*def.OperandWidths[i]) - 1
+		if o < 0 || o > max {
+			return fmt.Errorf("operand %d of %s out of range: %d (max %d)", i, def.Name, o, max)
+		}
+	}
+	return nil
+}
+
+// オペランドを読み込み、読み込んだバイト数とともに返す
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+
+	for i, width := range def.OperandWidths {
+		switch width {
+		case 2:
+			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
+		}
+		offset += width
+	}
+
+	return operands, offset
+}
+
+func ReadUint16(ins Instructions) uint16 {
+	return binary.BigEndian.Uint16(ins)
+}
+
+func ReadUint8(ins Instructions) uint8 {
+	return uint8(ins[0])
+}
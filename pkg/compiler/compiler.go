@@ -0,0 +1,703 @@
+package compiler
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/oteto/gonkey/pkg/ast"
+	"github.com/oteto/gonkey/pkg/code"
+	"github.com/oteto/gonkey/pkg/evaluator"
+	"github.com/oteto/gonkey/pkg/object"
+)
+
+// コンパイル結果。vm.New に渡して実行する
+type Bytecode struct {
+	Instructions code.Instructions
+	Constants    []object.Object
+	Globals      []string // グローバル変数名（インデックス順）
+}
+
+type EmittedInstruction struct {
+	Opcode   code.Opcode
+	Position int
+}
+
+// 関数ごとの命令列
+type CompilationScope struct {
+	instructions        code.Instructions
+	lastInstruction     EmittedInstruction
+	previousInstruction EmittedInstruction
+}
+
+// ast.Program をバイトコードへ変換する
+type Compiler struct {
+	constants []object.Object
+	names     map[string]int // 名前の文字列定数のインデックス
+
+	symbolTable *SymbolTable
+
+	scopes     []CompilationScope
+	scopeIndex int
+
+	// 変数でない名前を探す組み込み関数と組み込みの値
+	builtins Builtins
+
+	// 命令のオペランドに収まらない値による最初のエラー
+	// 変数や引数、定数が多すぎるか、関数が長すぎる場合に設定する
+	err error
+}
+
+var infixOpcodes = map[string]code.Opcode{
+	"+":  code.OpAdd,
+	"-":  code.OpSub,
+	"*":  code.OpMul,
+	"/":  code.OpDiv,
+	"==": code.OpEqual,
+	"!=": code.OpNotEqual,
+	">":  code.OpGreaterThan,
+	"<":  code.OpLessThan,
+}
+
+var prefixOpcodes = map[string]code.Opcode{
+	"!": code.OpBang,
+	"-": code.OpMinus,
+}
+
+// 組み込み関数と組み込みの値を名前で探す。*evaluator.Interpreter が満たす
+type Builtins interface {
+	LookupBuiltin(name string) (*object.Builtin, bool)
+	LookupValue(name string) (object.Object, bool)
+}
+
+type Option func(*Compiler)
+
+// 実行に使うインタプリタの組み込み関数と組み込みの値（デフォルトは evaluator.New() のもの）
+// 変数でも組み込みでもない名前はコンパイルエラーにする
+func WithBuiltins(b Builtins) Option {
+	return func(c *Compiler) {
+		c.builtins = b
+	}
+}
+
+func New(opts ...Option) *Compiler {
+	mainScope := CompilationScope{
+		instructions:        code.Instructions{},
+		lastInstruction:     EmittedInstruction{},
+		previousInstruction: EmittedInstruction{},
+	}
+
+	c := &Compiler{
+		constants:   []object.Object{},
+		names:       make(map[string]int),
+		symbolTable: NewSymbolTable(),
+		scopes:      []CompilationScope{mainScope},
+		scopeIndex:  0,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.builtins == nil {
+		c.builtins = evaluator.New()
+	}
+	return c
+}
+
+// REPL のように、前回のコンパイル結果のグローバル変数と定数を引き継いでコンパイルする
+func NewWithState(s *SymbolTable, constants []object.Object, opts ...Option) *Compiler {
+	compiler := New(opts...)
+	compiler.symbolTable = s
+	compiler.constants = constants
+	return compiler
+}
+
+func (c *Compiler) Compile(node ast.Node) (err error) {
+	defer func() {
+		if err == nil {
+			err = c.err
+		}
+	}()
+
+	switch node := node.(type) {
+	case *ast.Program:
+		c.defineGlobals(node)
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+	case *ast.ExpressionStatement:
+		if err := c.Compile(node.Expression); err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+	case *ast.LetStatement:
+		if fl, ok := node.Value.(*ast.FunctionLiteral); ok && c.scopeIndex > 0 {
+			if err := c.compileFunctionLiteral(fl, node.Name.Value); err != nil {
+				return err
+			}
+		} else if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		symbol := c.symbolTable.Define(node.Name.Value)
+		switch {
+		case symbol.Scope == GlobalScope:
+			c.emit(code.OpSetGlobal, symbol.Index)
+		case symbol.Cell:
+			c.emit(code.OpSetCell, symbol.Index)
+		default:
+			c.emit(code.OpSetLocal, symbol.Index)
+		}
+	case *ast.ReturnStatement:
+		if err := c.Compile(node.ReturnValue); err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+	case *ast.InfixExpression:
+		if node.Operator == "|>" {
+			return c.compilePipeExpression(node)
+		}
+		op, ok := infixOpcodes[node.Operator]
+		if !ok {
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		c.emit(op)
+	case *ast.PrefixExpression:
+		op, ok := prefixOpcodes[node.Operator]
+		if !ok {
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		c.emit(op)
+	case *ast.IfExpression:
+		return c.compileIfExpression(node)
+	case *ast.IntegerLiteral:
+		integer := &object.Integer{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(integer))
+	case *ast.StringLiteral:
+		str := &object.String{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(str))
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+	case *ast.Identifer:
+		symbol, ok := c.symbolTable.Resolve(node.Value)
+		if !ok {
+			// tree-walker と同じく、組み込み関数・組み込みの値でもなければ実行前にエラーにする
+			if !c.isBuiltin(node.Value) {
+				return errors.New(evaluator.IDENTIFIER_NOT_FOUND_ERROR_PREFIX + node.Value)
+			}
+			// 組み込み関数は実行時に名前で探す
+			c.emit(code.OpGetBuiltin, c.nameConstant(node.Value))
+			return nil
+		}
+		c.loadSymbol(symbol)
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			if err := c.Compile(el); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpArray, len(node.Elements))
+	case *ast.HashLiteral:
+		keys := node.OrderedKeys()
+		for _, k := range keys {
+			if err := c.Compile(k); err != nil {
+				return err
+			}
+			if err := c.Compile(node.Pairs[k]); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpHash, len(keys)*2)
+	case *ast.IndexExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+		c.emit(code.OpIndex)
+	case *ast.SliceExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		bounds := 0
+		if node.Low != nil {
+			if err := c.Compile(node.Low); err != nil {
+				return err
+			}
+			bounds |= code.SliceLow
+		}
+		if node.High != nil {
+			if err := c.Compile(node.High); err != nil {
+				return err
+			}
+			bounds |= code.SliceHigh
+		}
+		c.emit(code.OpSlice, bounds)
+	case *ast.PropertyExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		c.emit(code.OpProperty, c.nameConstant(node.Property.Value))
+	case *ast.FunctionLiteral:
+		return c.compileFunctionLiteral(node, "")
+	case *ast.CallExpression:
+		return c.compileCallExpression(node, node.Arguments)
+	default:
+		return fmt.Errorf("unsupported node %T", node)
+	}
+
+	return nil
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	return &Bytecode{
+		Instructions: c.currentInstructions(),
+		Constants:    c.constants,
+		Globals:      c.symbolTable.names(GlobalScope),
+	}
+}
+
+func (c *Compiler) isBuiltin(name string) bool {
+	if _, ok := c.builtins.LookupBuiltin(name); ok {
+		return true
+	}
+	_, ok := c.builtins.LookupValue(name)
+	return ok
+}
+
+// 関数の外にある let 文で定義されるグローバル変数を先に宣言する
+// tree-walker と同じく、関数の中からは後で定義されるグローバル変数を参照でき、
+// 関数の外では定義より前に参照できない
+func (c *Compiler) defineGlobals(program *ast.Program) {
+	if c.scopeIndex > 0 {
+		return
+	}
+	ast.Inspect(program, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FunctionLiteral:
+			return false
+		case *ast.LetStatement:
+			if n.Name != nil {
+				c.symbolTable.Declare(n.Name.Value)
+			}
+		}
+		return true
+	})
+}
+
+// 関数内の let 文で定義されるローカル変数を先に宣言する
+// tree-walker と同じく、内側の関数から後で定義される変数を参照できるようにする
+// 内側の関数から参照される let 文の変数は、取り込んだ後の代入も見えるように Cell に入れる
+// let 文で再定義されない引数は呼び出し時に代入されたままなので、値を取り込めばよい
+func (c *Compiler) declareLocals(body *ast.BlockStatement) {
+	lets := make(map[string]bool)
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FunctionLiteral:
+			return false
+		case *ast.LetStatement:
+			if n.Name != nil {
+				c.symbolTable.Declare(n.Name.Value)
+				lets[n.Name.Value] = true
+			}
+		}
+		return true
+	})
+
+	captured := capturedNames(body)
+	for index, name := range c.symbolTable.names(LocalScope) {
+		if lets[name] && captured[name] {
+			c.symbolTable.defineCell(name)
+			c.emit(code.OpMakeCell, index)
+		}
+	}
+}
+
+// 内側の関数から参照される可能性のある、body の外側の変数の名前
+// 内側の関数の let 文で隠される名前も含むので、必要より多くの変数を Cell に入れることがある
+func capturedNames(body *ast.BlockStatement) map[string]bool {
+	names := make(map[string]bool)
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.LetStatement:
+			// 関数の中の let 文で束縛される関数は、自身の名前を OpCurrentClosure で参照する
+			if fl, ok := n.Value.(*ast.FunctionLiteral); ok && n.Name != nil {
+				for name := range referencedNames(fl, n.Name.Value) {
+					names[name] = true
+				}
+				return false
+			}
+		case *ast.FunctionLiteral:
+			for name := range referencedNames(n, "") {
+				names[name] = true
+			}
+			return false
+		}
+		return true
+	})
+	return names
+}
+
+// fl が外側の変数として参照する可能性のある名前
+func referencedNames(fl *ast.FunctionLiteral, self string) map[string]bool {
+	names := capturedNames(fl.Body)
+	shadowed := false
+	ast.Inspect(fl.Body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FunctionLiteral:
+			return false
+		case *ast.LetStatement:
+			if n.Name != nil && n.Name.Value == self {
+				shadowed = true
+			}
+		case *ast.Identifer:
+			names[n.Value] = true
+		}
+		return true
+	})
+	for _, p := range fl.Patameters {
+		delete(names, p.Value)
+	}
+	if self != "" && !shadowed {
+		delete(names, self)
+	}
+	return names
+}
+
+// 戻り値をそのまま返す呼び出しを、呼び出し元のフレームを再利用する OpTailCall にする
+// 呼び出しの直後が OpReturnValue か、OpJump を辿った先が OpReturnValue のものが対象
+func (c *Compiler) markTailCalls() {
+	ins := c.currentInstructions()
+	for pos := 0; pos < len(ins); {
+		op := code.Opcode(ins[pos])
+		def, _ := code.Lookup(byte(op))
+		_, read := code.ReadOperands(def, ins[pos+1:])
+		next := pos + 1 + read
+
+		if op == code.OpCall && returnsAt(ins, next) {
+			ins[pos] = byte(code.OpTailCall)
+		}
+		pos = next
+	}
+}
+
+func returnsAt(ins code.Instructions, pos int) bool {
+	for pos < len(ins) {
+		switch code.Opcode(ins[pos]) {
+		case code.OpReturnValue:
+			return true
+		case code.OpJump:
+			pos = int(code.ReadUint16(ins[pos+1:]))
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+func (c *Compiler) compileIfExpression(node *ast.IfExpression) error {
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+
+	// 後で飛び先を埋める
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	if err := c.compileBlockValue(node.Consequence); err != nil {
+		return err
+	}
+
+	jumpPos := c.emit(code.OpJump, 9999)
+
+	afterConsequencePos := len(c.currentInstructions())
+	c.changeOperand(jumpNotTruthyPos, afterConsequencePos)
+
+	if node.Alternative == nil {
+		c.emit(code.OpNull)
+	} else if err := c.compileBlockValue(node.Alternative); err != nil {
+		return err
+	}
+
+	afterAlternativePos := len(c.currentInstructions())
+	c.changeOperand(jumpPos, afterAlternativePos)
+
+	return nil
+}
+
+// ブロックの最後の式の値をスタックに残す
+// 最後が式でない場合は null を残す
+func (c *Compiler) compileBlockValue(block *ast.BlockStatement) error {
+	start := len(c.currentInstructions())
+	if err := c.Compile(block); err != nil {
+		return err
+	}
+
+	if len(c.currentInstructions()) > start && c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	} else {
+		c.emit(code.OpNull)
+	}
+	return nil
+}
+
+// name は let で束縛される名前（再帰呼び出し用）。無名関数の場合は空文字列
+func (c *Compiler) compileFunctionLiteral(node *ast.FunctionLiteral, name string) error {
+	c.enterScope()
+
+	if name != "" {
+		c.symbolTable.DefineFunctionName(name)
+	}
+
+	for _, p := range node.Patameters {
+		c.symbolTable.Define(p.Value)
+	}
+	c.declareLocals(node.Body)
+
+	if err := c.Compile(node.Body); err != nil {
+		return err
+	}
+
+	if c.lastInstructionIs(code.OpPop) {
+		c.replaceLastPopWithReturn()
+	}
+	if !c.lastInstructionIs(code.OpReturnValue) {
+		c.emit(code.OpReturn)
+	}
+	c.markTailCalls()
+
+	freeSymbols := c.symbolTable.FreeSymbols
+	numLocals := c.symbolTable.numDefinitions
+	localNames := c.symbolTable.names(LocalScope)
+	freeNames := c.symbolTable.freeNames()
+	instructions := c.leaveScope()
+
+	for _, s := range freeSymbols {
+		c.captureSymbol(s)
+	}
+
+	compiledFn := &object.CompiledFunction{
+		Instructions:  instructions,
+		NumLocals:     numLocals,
+		NumParameters: len(node.Patameters),
+		LocalNames:    localNames,
+		FreeNames:     freeNames,
+		Source:        (&object.Function{Parameters: node.Patameters, Body: node.Body}).Inspect(),
+	}
+	c.emit(code.OpClosure, c.addConstant(compiledFn), len(freeSymbols))
+
+	return nil
+}
+
+// args を引数にして node の関数を呼び出す
+// 関数を積んでから、引数を OpCatch で囲んで順に積む
+// 引数の実行時エラーは、呼び出す関数がエラーを受け取る組み込み関数の場合だけ VM が引数の値にする
+func (c *Compiler) compileCallExpression(node *ast.CallExpression, args []ast.Expression) error {
+	property, isMethod := node.Function.(*ast.PropertyExpression)
+	if !isMethod {
+		if err := c.Compile(node.Function); err != nil {
+			return err
+		}
+		for i, a := range args {
+			if err := c.compileCaught(a, code.OpCatch, i); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpCall, len(args))
+		return nil
+	}
+
+	name := c.nameConstant(property.Property.Value)
+	if err := c.compileCaught(property.Left, code.OpCatchReceiver, name); err != nil {
+		return err
+	}
+	for i, a := range args {
+		if err := c.compileCaught(a, code.OpCatchMethod, name, i); err != nil {
+			return err
+		}
+	}
+	c.emit(code.OpMethod, name, len(args))
+	return nil
+}
+
+// node を捕捉先を登録する命令 op で囲む。op の最初のオペランドは捕捉した後に続ける位置
+func (c *Compiler) compileCaught(node ast.Expression, op code.Opcode, operands ...int) error {
+	operands = append([]int{9999}, operands...)
+	catchPos := c.emit(op, operands...)
+	if err := c.Compile(node); err != nil {
+		return err
+	}
+	c.emit(code.OpEndCatch)
+	operands[0] = len(c.currentInstructions())
+	c.changeOperand(catchPos, operands...)
+	return nil
+}
+
+// 通常の呼び出しと同じく、右辺の関数を先に積んでから左辺値を第１引数として積む
+func (c *Compiler) compilePipeExpression(node *ast.InfixExpression) error {
+	if call, ok := node.Right.(*ast.CallExpression); ok {
+		return c.compileCallExpression(call, append([]ast.Expression{node.Left}, call.Arguments...))
+	}
+	if err := c.Compile(node.Right); err != nil {
+		return err
+	}
+	if err := c.compileCaught(node.Left, code.OpCatch, 0); err != nil {
+		return err
+	}
+	c.emit(code.OpCall, 1)
+	return nil
+}
+
+// 変数の値を積む
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch {
+	case s.Scope == LocalScope && s.Cell:
+		c.emit(code.OpGetCell, s.Index)
+	case s.Scope == FreeScope && s.Cell:
+		c.emit(code.OpGetFreeCell, s.Index)
+	default:
+		c.captureSymbol(s)
+	}
+}
+
+// クロージャに取り込む値を積む。Cell に入れた変数は Cell のまま積む
+func (c *Compiler) captureSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpGetLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
+	}
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+// 組み込み関数名やプロパティ名を文字列定数として追加する
+// 同じ名前は同じ定数を使い回す
+func (c *Compiler) nameConstant(name string) int {
+	if idx, ok := c.names[name]; ok {
+		return idx
+	}
+	idx := c.addConstant(&object.String{Value: name})
+	c.names[name] = idx
+	return idx
+}
+
+// 命令を追加し、その位置を返す
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	c.checkOperands(op, operands...)
+	ins := code.Make(op, operands...)
+	pos := c.addInstruction(ins)
+	c.setLastInstruction(op, pos)
+	return pos
+}
+
+func (c *Compiler) addInstruction(ins []byte) int {
+	posNewInstruction := len(c.currentInstructions())
+	c.scopes[c.scopeIndex].instructions = append(c.currentInstructions(), ins...)
+	return posNewInstruction
+}
+
+func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
+	previous := c.scopes[c.scopeIndex].lastInstruction
+	last := EmittedInstruction{Opcode: op, Position: pos}
+
+	c.scopes[c.scopeIndex].previousInstruction = previous
+	c.scopes[c.scopeIndex].lastInstruction = last
+}
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
+	if len(c.currentInstructions()) == 0 {
+		return false
+	}
+	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
+}
+
+func (c *Compiler) removeLastPop() {
+	last := c.scopes[c.scopeIndex].lastInstruction
+	previous := c.scopes[c.scopeIndex].previousInstruction
+
+	old := c.currentInstructions()
+	c.scopes[c.scopeIndex].instructions = old[:last.Position]
+	c.scopes[c.scopeIndex].lastInstruction = previous
+}
+
+func (c *Compiler) replaceLastPopWithReturn() {
+	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
+	c.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
+	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
+}
+
+func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
+	ins := c.currentInstructions()
+	for i := 0; i < len(newInstruction); i++ {
+		ins[pos+i] = newInstruction[i]
+	}
+}
+
+func (c *Compiler) changeOperand(opPos int, operands ...int) {
+	op := code.Opcode(c.currentInstructions()[opPos])
+	c.checkOperands(op, operands...)
+	newInstruction := code.Make(op, operands...)
+	c.replaceInstruction(opPos, newInstruction)
+}
+
+// オペランドが収まらない場合は、切り詰めた命令で誤った結果にならないようにコンパイルを失敗させる
+func (c *Compiler) checkOperands(op code.Opcode, operands ...int) {
+	if c.err != nil {
+		return
+	}
+	if err := code.CheckOperands(op, operands...); err != nil {
+		c.err = fmt.Errorf("program too large: %w", err)
+	}
+}
+
+func (c *Compiler) enterScope() {
+	scope := CompilationScope{
+		instructions:        code.Instructions{},
+		lastInstruction:     EmittedInstruction{},
+		previousInstruction: EmittedInstruction{},
+	}
+	c.scopes = append(c.scopes, scope)
+	c.scopeIndex++
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveScope() code.Instructions {
+	instructions := c.currentInstructions()
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+	c.symbolTable = c.symbolTable.Outer
+
+	return instructions
+}
@@ -0,0 +1,457 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/oteto/gonkey/pkg/ast"
+	"github.com/oteto/gonkey/pkg/code"
+	"github.com/oteto/gonkey/pkg/object"
+	"github.com/oteto/gonkey/pkg/parser"
+	"github.com/oteto/gonkey/pkg/tokenizer"
+)
+
+type compilerTestCase struct {
+	input                string
+	expectedConstants    []interface{}
+	expectedInstructions []code.Instructions
+}
+
+func TestIntegerArithmetic(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 + 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "-1",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpMinus),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "if (true) { 10 }; 3333;",
+			expectedConstants: []interface{}{10, 3333},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpJumpNotTruthy, 10),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpJump, 11),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestGlobalLetStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let one = 1; let two = one;",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpSetGlobal, 1),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestBuiltins(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "len([]); len(\"\");",
+			expectedConstants: []interface{}{"len", ""},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetBuiltin, 0),
+				code.Make(code.OpCatch, 11, 0),
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpEndCatch),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpGetBuiltin, 0),
+				code.Make(code.OpCatch, 25, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpEndCatch),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "is_error(1)",
+			expectedConstants: []interface{}{"is_error", 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetBuiltin, 0),
+				code.Make(code.OpCatch, 11, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpEndCatch),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let is_error = 1; is_error(1)",
+			expectedConstants: []interface{}{1, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpCatch, 17, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpEndCatch),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestSliceAndProperty(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "[][1:]",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSlice, code.SliceLow),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "[].len()",
+			expectedConstants: []interface{}{"len"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpCatchReceiver, 9, 0),
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpEndCatch),
+				code.Make(code.OpMethod, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 |> push(2)",
+			expectedConstants: []interface{}{"push", 1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetBuiltin, 0),
+				code.Make(code.OpCatch, 11, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpEndCatch),
+				code.Make(code.OpCatch, 19, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpEndCatch),
+				code.Make(code.OpCall, 2),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestClosures(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "fn(a) { fn(b) { a + b } }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestCells(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "fn() { let g = fn() { x }; let x = 5; g() }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFreeCell, 0),
+					code.Make(code.OpReturnValue),
+				},
+				5,
+				[]code.Instructions{
+					code.Make(code.OpMakeCell, 1),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpSetCell, 1),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpTailCall, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "let wrapper = fn() { let countDown = fn(x) { countDown(x - 1); }; countDown(1); };",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpCurrentClosure),
+					code.Make(code.OpCatch, 12, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSub),
+					code.Make(code.OpEndCatch),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+				[]code.Instructions{
+					code.Make(code.OpClosure, 1, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCatch, 16, 0),
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpEndCatch),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestDefine(t *testing.T) {
+	global := NewSymbolTable()
+	a := global.Define("a")
+	b := global.Define("b")
+	if a != (Symbol{Name: "a", Scope: GlobalScope, Index: 0}) {
+		t.Errorf("a wrong. got=%+v", a)
+	}
+	if b != (Symbol{Name: "b", Scope: GlobalScope, Index: 1}) {
+		t.Errorf("b wrong. got=%+v", b)
+	}
+	if again := global.Define("a"); again != a {
+		t.Errorf("redefined a wrong. got=%+v", again)
+	}
+
+	local := NewEnclosedSymbolTable(global)
+	c := local.Define("c")
+	if c != (Symbol{Name: "c", Scope: LocalScope, Index: 0}) {
+		t.Errorf("c wrong. got=%+v", c)
+	}
+
+	nested := NewEnclosedSymbolTable(local)
+	free, ok := nested.Resolve("c")
+	if !ok || free != (Symbol{Name: "c", Scope: FreeScope, Index: 0}) {
+		t.Errorf("free c wrong. got=%+v", free)
+	}
+	if len(nested.FreeSymbols) != 1 || nested.FreeSymbols[0] != c {
+		t.Errorf("FreeSymbols wrong. got=%+v", nested.FreeSymbols)
+	}
+}
+
+// オペランドに収まらないプログラムは、切り詰めた命令にせずエラーにする
+func TestOperandLimits(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		ok    bool
+	}{
+		{"256 locals", manyLocals(256), true},
+		{"257 locals", manyLocals(257), false},
+		{"255 arguments", manyArguments(255), true},
+		{"256 arguments", manyArguments(256), false},
+		{"65536 constants", manyConstants(65536), true},
+		{"65537 constants", manyConstants(65537), false},
+		// 飛び先は 2k+7 なので、32764 文で 65535、32765 文で 65537 になる
+		{"jump to 65535", longBranch(32764), true},
+		{"jump to 65537", longBranch(32765), false},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(tokenizer.New(tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Fatalf("%s: parse error: %s", tt.name, p.Errors()[0])
+		}
+		err := New().Compile(program)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: wrong result. got=%v", tt.name, err)
+		}
+	}
+}
+
+// n 個のローカル変数を定義し、最初と最後を足す関数
+func manyLocals(n int) string {
+	var b strings.Builder
+	b.WriteString("fn() { ")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "let %s = %d; ", letterName(i), i)
+	}
+	fmt.Fprintf(&b, "%s + %s }()", letterName(0), letterName(n-1))
+	return b.String()
+}
+
+// n 個の引数で呼び出し、最初と最後の引数を足す関数
+func manyArguments(n int) string {
+	params := make([]string, n)
+	args := make([]string, n)
+	for i := range params {
+		params[i] = letterName(i)
+		args[i] = fmt.Sprint(i)
+	}
+	return fmt.Sprintf("fn(%s) { %s + %s }(%s)", strings.Join(params, ", "), params[0], params[n-1], strings.Join(args, ", "))
+}
+
+// 識別子に数字は使えないので、i を英小文字だけの名前にする
+func letterName(i int) string {
+	name := ""
+	for {
+		name = string(rune('a'+i%26)) + name
+		i /= 26
+		if i == 0 {
+			return "v" + name
+		}
+	}
+}
+
+// n 個の整数定数を使う文
+func manyConstants(n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "%d; ", i)
+	}
+	return b.String()
+}
+
+// 条件が真の場合に n 個の文を実行する if 式
+func longBranch(n int) string {
+	return "if (true) { " + strings.Repeat("true; ", n) + "}"
+}
+
+func runCompilerTests(t *testing.T, tests []compilerTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		compiler := New()
+		if err := compiler.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		bytecode := compiler.Bytecode()
+
+		if err := testInstructions(tt.expectedInstructions, bytecode.Instructions); err != nil {
+			t.Fatalf("%s: testInstructions failed: %s", tt.input, err)
+		}
+		if err := testConstants(tt.expectedConstants, bytecode.Constants); err != nil {
+			t.Fatalf("%s: testConstants failed: %s", tt.input, err)
+		}
+	}
+}
+
+func parse(input string) *ast.Program {
+	p := parser.New(tokenizer.New(input))
+	return p.ParseProgram()
+}
+
+func testInstructions(expected []code.Instructions, actual code.Instructions) error {
+	concatted := code.Instructions{}
+	for _, ins := range expected {
+		concatted = append(concatted, ins...)
+	}
+
+	if len(actual) != len(concatted) {
+		return fmt.Errorf("wrong instructions length.\nwant=%q\ngot =%q", concatted, actual)
+	}
+	for i, ins := range concatted {
+		if actual[i] != ins {
+			return fmt.Errorf("wrong instruction at %d.\nwant=%q\ngot =%q", i, concatted, actual)
+		}
+	}
+	return nil
+}
+
+func testConstants(expected []interface{}, actual []object.Object) error {
+	if len(expected) != len(actual) {
+		return fmt.Errorf("wrong number of constants. want=%d, got=%d", len(expected), len(actual))
+	}
+
+	for i, constant := range expected {
+		switch constant := constant.(type) {
+		case int:
+			integer, ok := actual[i].(*object.Integer)
+			if !ok || integer.Value != int64(constant) {
+				return fmt.Errorf("constant %d - wrong integer. want=%d, got=%+v", i, constant, actual[i])
+			}
+		case string:
+			str, ok := actual[i].(*object.String)
+			if !ok || str.Value != constant {
+				return fmt.Errorf("constant %d - wrong string. want=%q, got=%+v", i, constant, actual[i])
+			}
+		case []code.Instructions:
+			fn, ok := actual[i].(*object.CompiledFunction)
+			if !ok {
+				return fmt.Errorf("constant %d - not a function: %T", i, actual[i])
+			}
+			if err := testInstructions(constant, fn.Instructions); err != nil {
+				return fmt.Errorf("constant %d - testInstructions failed: %s", i, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package compiler
+
+type SymbolScope string
+
+const (
+	GlobalScope   SymbolScope = "GLOBAL"
+	LocalScope    SymbolScope = "LOCAL"
+	BuiltinScope  SymbolScope = "BUILTIN"
+	FreeScope     SymbolScope = "FREE"
+	FunctionScope SymbolScope = "FUNCTION"
+)
+
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+	Cell  bool // 内側の関数に取り込まれるため Cell に入れたローカル変数（FREE の場合はそれを取り込んだもの）
+}
+
+// 識別子とスコープ・インデックスの対応を管理する
+// 関数ごとに Outer を辿れるテーブルを作る
+type SymbolTable struct {
+	Outer *SymbolTable
+
+	store          map[string]Symbol
+	defined        map[string]bool // 宣言だけでなく定義まで済んだ変数
+	free           map[string]Symbol
+	numDefinitions int
+
+	FreeSymbols []Symbol
+}
+
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{
+		store:       make(map[string]Symbol),
+		defined:     make(map[string]bool),
+		free:        make(map[string]Symbol),
+		FreeSymbols: []Symbol{},
+	}
+}
+
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
+// 識別子を定義する
+// 同じスコープで宣言・定義済みの場合は同じインデックスを使い回す
+func (s *SymbolTable) Define(name string) Symbol {
+	symbol := s.Declare(name)
+	s.defined[name] = true
+	return symbol
+}
+
+// 識別子を定義より前に宣言する
+// 宣言だけした変数は、内側の関数からは参照できるが、同じ関数では定義するまで外側の変数を参照する
+func (s *SymbolTable) Declare(name string) Symbol {
+	if symbol, ok := s.store[name]; ok && (symbol.Scope == GlobalScope || symbol.Scope == LocalScope) {
+		return symbol
+	}
+
+	symbol := Symbol{Name: name, Index: s.numDefinitions}
+	if s.Outer == nil {
+		symbol.Scope = GlobalScope
+	} else {
+		symbol.Scope = LocalScope
+	}
+
+	s.store[name] = symbol
+	s.numDefinitions++
+	return symbol
+}
+
+// 関数自身の名前を定義する（再帰呼び出し用）
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Index: 0, Scope: FunctionScope}
+	s.store[name] = symbol
+	s.defined[name] = true
+	return symbol
+}
+
+// ローカル変数を Cell に入れる変数にする
+func (s *SymbolTable) defineCell(name string) Symbol {
+	symbol := s.store[name]
+	symbol.Cell = true
+	s.store[name] = symbol
+	return symbol
+}
+
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	return s.resolve(name, false)
+}
+
+// inner が true の場合は内側の関数からの参照として、宣言だけした変数も返す
+func (s *SymbolTable) resolve(name string, inner bool) (Symbol, bool) {
+	if obj, ok := s.store[name]; ok && (inner || s.defined[name]) {
+		return obj, true
+	}
+	if obj, ok := s.free[name]; ok {
+		return obj, true
+	}
+	if s.Outer == nil {
+		return Symbol{}, false
+	}
+
+	obj, ok := s.Outer.resolve(name, true)
+	if !ok {
+		return obj, ok
+	}
+
+	if obj.Scope == GlobalScope || obj.Scope == BuiltinScope {
+		return obj, ok
+	}
+
+	free := s.defineFree(obj)
+	return free, true
+}
+
+// グローバル変数の数
+func (s *SymbolTable) NumDefinitions() int {
+	return s.numDefinitions
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1, Cell: original.Cell}
+	symbol.Scope = FreeScope
+
+	s.free[original.Name] = symbol
+	return symbol
+}
+
+// 自由変数名をインデックス順に返す
+func (s *SymbolTable) freeNames() []string {
+	names := make([]string, len(s.FreeSymbols))
+	for i, symbol := range s.FreeSymbols {
+		names[i] = symbol.Name
+	}
+	return names
+}
+
+// このテーブルで定義された変数名をインデックス順に返す
+func (s *SymbolTable) names(scope SymbolScope) []string {
+	names := make([]string, s.numDefinitions)
+	for name, symbol := range s.store {
+		if symbol.Scope == scope {
+			names[symbol.Index] = name
+		}
+	}
+	return names
+}
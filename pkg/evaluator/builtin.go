@@ -2,34 +2,89 @@ package evaluator
 
 import (
 	"io"
-	"os"
+	"sort"
 
 	"github.com/oteto/gonkey/pkg/object"
 )
 
 const (
-	BUILTIN_NUMBER_OF_ARGUMENT_ERROR = "wrong number of arguments. got=%d, want=%d"
-	BUILTIN_ARGUMENT_TYPE_ERRROR     = "argument to `%s` not supported, got %s"
+	BUILTIN_NUMBER_OF_ARGUMENT_ERROR       = "wrong number of arguments. got=%d, want=%d"
+	BUILTIN_NUMBER_OF_ARGUMENT_RANGE_ERROR = "wrong number of arguments. got=%d, want=%d..%d"
+	BUILTIN_ARGUMENT_TYPE_ERRROR           = "argument to `%s` not supported, got %s"
 )
 
-var builtins = map[string]*object.Builtin{
-	"len":   {Fn: builtinLen},
-	"first": {Fn: builtinFirst},
-	"last":  {Fn: builtinLast},
-	"rest":  {Fn: builtinRest},
-	"push":  {Fn: builtinPush},
-	"puts":  {Fn: builtinPuts},
+// インタプリタごとの組み込み関数
+func (in *Interpreter) newBuiltins() map[string]*object.Builtin {
+	builtins := map[string]*object.Builtin{
+		"len":   {Fn: builtinLen},
+		"first": {Fn: builtinFirst},
+		"last":  {Fn: builtinLast},
+		"rest":  {Fn: builtinRest},
+		"push":  {Fn: builtinPush},
+		"puts":  {Fn: in.builtinPuts},
+		"eputs": {Fn: in.builtinEputs},
+
+		"keys":    {Fn: builtinKeys},
+		"values":  {Fn: builtinValues},
+		"entries": {Fn: builtinEntries},
+		"has":     {Fn: builtinHas},
+		"delete":  {Fn: builtinDelete},
+		"merge":   {Fn: builtinMerge},
+	}
+	groups := []map[string]*object.Builtin{
+		in.newHigherOrderBuiltins(),
+		in.newStringBuiltins(),
+		newConvertBuiltins(),
+		in.newJSONBuiltins(),
+		in.newRegexBuiltins(),
+		in.newFileBuiltins(),
+		in.newInputBuiltins(),
+		in.newTimeBuiltins(),
+	}
+	for _, group := range groups {
+		for name, builtin := range group {
+			builtins[name] = builtin
+		}
+	}
+	return builtins
 }
 
-var Output io.Writer = os.Stdout
+// インタプリタごとの組み込みの値
+// 組み込み関数と同じく、同じ名前の変数を定義すると隠れる
+func (in *Interpreter) newValues() map[string]object.Object {
+	return map[string]object.Object{
+		"math": in.newMathModule(),
+		"args": stringArray(in.config.args),
+	}
+}
+
+// 名前の順に組み込み関数を並べたハッシュを作る
+func newModule(builtins map[string]*object.Builtin) *object.Hash {
+	names := make([]string, 0, len(builtins))
+	for name := range builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-func SetWriter(w io.Writer) {
-	Output = w
+	module := object.NewHash(len(names))
+	for _, name := range names {
+		key := &object.String{Value: name}
+		module.Set(key.HashKey(), object.HashPair{Key: key, Value: builtins[name]})
+	}
+	return module
 }
 
-func builtinPuts(args ...object.Object) object.Object {
+func (in *Interpreter) builtinPuts(args ...object.Object) object.Object {
 	for _, arg := range args {
-		io.WriteString(Output, arg.Inspect()+"\n")
+		io.WriteString(in.config.stdout, arg.Inspect()+"\n")
+	}
+	return NULL
+}
+
+// puts と同じく引数を１行ずつ、WithStderr で渡されたエラー出力に書く
+func (in *Interpreter) builtinEputs(args ...object.Object) object.Object {
+	for _, arg := range args {
+		io.WriteString(in.config.stderr, arg.Inspect()+"\n")
 	}
 	return NULL
 }
@@ -101,7 +156,121 @@ func builtinLen(args ...object.Object) object.Object {
 		return &object.Integer{Value: int64(len(arg.Value))}
 	case *object.Array:
 		return &object.Integer{Value: int64(len(arg.Elements))}
+	case *object.Hash:
+		return &object.Integer{Value: int64(len(arg.Pairs))}
 	default:
 		return newError(BUILTIN_ARGUMENT_TYPE_ERRROR, "len", arg.Type())
 	}
 }
+
+func builtinKeys(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return newError(BUILTIN_NUMBER_OF_ARGUMENT_ERROR, len(args), 1)
+	}
+	hash, ok := args[0].(*object.Hash)
+	if !ok {
+		return newError(BUILTIN_ARGUMENT_TYPE_ERRROR, "keys", args[0].Type())
+	}
+	pairs := hash.OrderedPairs()
+	keys := make([]object.Object, len(pairs))
+	for i, pair := range pairs {
+		keys[i] = pair.Key
+	}
+	return &object.Array{Elements: keys}
+}
+
+func builtinValues(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return newError(BUILTIN_NUMBER_OF_ARGUMENT_ERROR, len(args), 1)
+	}
+	hash, ok := args[0].(*object.Hash)
+	if !ok {
+		return newError(BUILTIN_ARGUMENT_TYPE_ERRROR, "values", args[0].Type())
+	}
+	pairs := hash.OrderedPairs()
+	values := make([]object.Object, len(pairs))
+	for i, pair := range pairs {
+		values[i] = pair.Value
+	}
+	return &object.Array{Elements: values}
+}
+
+// [キー, 値] の配列の配列を返す
+func builtinEntries(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return newError(BUILTIN_NUMBER_OF_ARGUMENT_ERROR, len(args), 1)
+	}
+	hash, ok := args[0].(*object.Hash)
+	if !ok {
+		return newError(BUILTIN_ARGUMENT_TYPE_ERRROR, "entries", args[0].Type())
+	}
+	pairs := hash.OrderedPairs()
+	entries := make([]object.Object, len(pairs))
+	for i, pair := range pairs {
+		entries[i] = &object.Array{Elements: []object.Object{pair.Key, pair.Value}}
+	}
+	return &object.Array{Elements: entries}
+}
+
+func builtinHas(args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return newError(BUILTIN_NUMBER_OF_ARGUMENT_ERROR, len(args), 2)
+	}
+	hash, ok := args[0].(*object.Hash)
+	if !ok {
+		return newError("first "+BUILTIN_ARGUMENT_TYPE_ERRROR, "has", args[0].Type())
+	}
+	key, ok := args[1].(object.Hashable)
+	if !ok {
+		return newError(UNUSABLE_HASH_KEY+"%s", args[1].Type())
+	}
+	_, ok = hash.Pairs[key.HashKey()]
+	return nativeBoolToBooleanObject(ok)
+}
+
+// key を除いた新しいハッシュを返す
+func builtinDelete(args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return newError(BUILTIN_NUMBER_OF_ARGUMENT_ERROR, len(args), 2)
+	}
+	hash, ok := args[0].(*object.Hash)
+	if !ok {
+		return newError("first "+BUILTIN_ARGUMENT_TYPE_ERRROR, "delete", args[0].Type())
+	}
+	key, ok := args[1].(object.Hashable)
+	if !ok {
+		return newError(UNUSABLE_HASH_KEY+"%s", args[1].Type())
+	}
+	deleted := key.HashKey()
+	newHash := object.NewHash(len(hash.Pairs))
+	for _, pair := range hash.OrderedPairs() {
+		hashKey := pair.Key.(object.Hashable).HashKey()
+		if hashKey != deleted {
+			newHash.Set(hashKey, pair)
+		}
+	}
+	return newHash
+}
+
+// 2 つのハッシュをまとめた新しいハッシュを返す
+// 同じキーは 2 つ目のハッシュの値を使う
+func builtinMerge(args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return newError(BUILTIN_NUMBER_OF_ARGUMENT_ERROR, len(args), 2)
+	}
+	first, ok := args[0].(*object.Hash)
+	if !ok {
+		return newError("first "+BUILTIN_ARGUMENT_TYPE_ERRROR, "merge", args[0].Type())
+	}
+	second, ok := args[1].(*object.Hash)
+	if !ok {
+		return newError("second "+BUILTIN_ARGUMENT_TYPE_ERRROR, "merge", args[1].Type())
+	}
+	newHash := object.NewHash(len(first.Pairs) + len(second.Pairs))
+	for _, hash := range []*object.Hash{first, second} {
+		for _, pair := range hash.OrderedPairs() {
+			newHash.Set(pair.Key.(object.Hashable).HashKey(), pair)
+		}
+	}
+	return newHash
+}
@@ -0,0 +1,334 @@
+package evaluator
+
+import (
+	"math"
+	"sort"
+
+	"github.com/oteto/gonkey/pkg/object"
+)
+
+const (
+	SORT_TYPE_MISMATCH   = "sort without comparator requires all INTEGER or all STRING elements, got %s"
+	SORT_COMPARATOR_TYPE = "comparator of `sort` must return BOOLEAN, got %s"
+	RANGE_STEP_ZERO      = "step of `range` must not be 0"
+)
+
+// スクリプトの関数を受け取る組み込み関数
+// 新しい配列を作るものは、要素数を実行制限の生成数として先に確認する
+func (in *Interpreter) newHigherOrderBuiltins() map[string]*object.Builtin {
+	return map[string]*object.Builtin{
+		"map":    {HigherOrderFn: in.builtinMap},
+		"filter": {HigherOrderFn: in.builtinFilter},
+		"reduce": {HigherOrderFn: builtinReduce},
+		"sort":   {HigherOrderFn: in.builtinSort},
+		"find":   {HigherOrderFn: builtinFind},
+		"any":    {HigherOrderFn: builtinAny},
+		"all":    {HigherOrderFn: builtinAll},
+		"zip":    {Fn: in.builtinZip},
+		"range":  {Fn: in.builtinRange},
+	}
+}
+
+// 第１引数の配列と第２引数の関数を取り出す
+func arrayAndFunction(name string, args []object.Object) (*object.Array, object.Object, *object.Error) {
+	if len(args) != 2 {
+		return nil, nil, newError(BUILTIN_NUMBER_OF_ARGUMENT_ERROR, len(args), 2)
+	}
+	arr, ok := args[0].(*object.Array)
+	if !ok {
+		return nil, nil, newError(BUILTIN_ARGUMENT_TYPE_MISMATCH, 1, name, object.ARRAY_OBJ, args[0].Type())
+	}
+	if !isCallable(args[1]) {
+		return nil, nil, newError(BUILTIN_ARGUMENT_TYPE_MISMATCH, 2, name, object.FUNCTION_OBJECT, args[1].Type())
+	}
+	return arr, args[1], nil
+}
+
+func isCallable(obj object.Object) bool {
+	switch obj.(type) {
+	case *object.Function, *object.Closure, *object.Builtin:
+		return true
+	}
+	return false
+}
+
+// 各要素に fn を適用した新しい配列を返す
+func (in *Interpreter) builtinMap(call object.CallFunction, args ...object.Object) object.Object {
+	arr, fn, err := arrayAndFunction("map", args)
+	if err != nil {
+		return err
+	}
+	if err := in.allocate(len(arr.Elements)); err != nil {
+		return err
+	}
+	elements := make([]object.Object, len(arr.Elements))
+	for i, el := range arr.Elements {
+		result := call(fn, el)
+		if isError(result) {
+			return result
+		}
+		elements[i] = result
+	}
+	return &object.Array{Elements: elements}
+}
+
+// fn の結果が真になる要素だけの新しい配列を返す
+func (in *Interpreter) builtinFilter(call object.CallFunction, args ...object.Object) object.Object {
+	arr, fn, err := arrayAndFunction("filter", args)
+	if err != nil {
+		return err
+	}
+	if err := in.allocate(len(arr.Elements)); err != nil {
+		return err
+	}
+	elements := []object.Object{}
+	for _, el := range arr.Elements {
+		result := call(fn, el)
+		if isError(result) {
+			return result
+		}
+		if isTruthy(result) {
+			elements = append(elements, el)
+		}
+	}
+	return &object.Array{Elements: elements}
+}
+
+// initial から始めて fn(累積値, 要素) を順に適用した値を返す
+func builtinReduce(call object.CallFunction, args ...object.Object) object.Object {
+	if len(args) != 3 {
+		return newError(BUILTIN_NUMBER_OF_ARGUMENT_ERROR, len(args), 3)
+	}
+	arr, fn, err := arrayAndFunction("reduce", args[:2])
+	if err != nil {
+		return err
+	}
+	acc := args[2]
+	for _, el := range arr.Elements {
+		acc = call(fn, acc, el)
+		if isError(acc) {
+			return acc
+		}
+	}
+	return acc
+}
+
+// 並べ替えた新しい配列を返す
+// less を渡さない場合は、すべて整数またはすべて文字列の配列を昇順に並べる
+// less(a, b) は a を b より前に置く場合に true を返す
+func (in *Interpreter) builtinSort(call object.CallFunction, args ...object.Object) object.Object {
+	if len(args) != 1 && len(args) != 2 {
+		return newError(BUILTIN_NUMBER_OF_ARGUMENT_RANGE_ERROR, len(args), 1, 2)
+	}
+	arr, ok := args[0].(*object.Array)
+	if !ok {
+		return newError(BUILTIN_ARGUMENT_TYPE_MISMATCH, 1, "sort", object.ARRAY_OBJ, args[0].Type())
+	}
+	if err := in.allocate(len(arr.Elements)); err != nil {
+		return err
+	}
+	elements := make([]object.Object, len(arr.Elements))
+	copy(elements, arr.Elements)
+
+	if len(args) == 1 {
+		less, err := naturalOrder(elements)
+		if err != nil {
+			return err
+		}
+		sort.SliceStable(elements, less)
+		return &object.Array{Elements: elements}
+	}
+
+	fn := args[1]
+	if !isCallable(fn) {
+		return newError(BUILTIN_ARGUMENT_TYPE_MISMATCH, 2, "sort", object.FUNCTION_OBJECT, fn.Type())
+	}
+	// 比較中にエラーが起きた場合は残りの比較を打ち切る
+	var sortErr object.Object
+	sort.SliceStable(elements, func(i, j int) bool {
+		if sortErr != nil {
+			return false
+		}
+		result := call(fn, elements[i], elements[j])
+		boolean, ok := result.(*object.Boolean)
+		if !ok {
+			sortErr = result
+			if !isError(result) {
+				sortErr = newError(SORT_COMPARATOR_TYPE, result.Type())
+			}
+			return false
+		}
+		return boolean.Value
+	})
+	if sortErr != nil {
+		return sortErr
+	}
+	return &object.Array{Elements: elements}
+}
+
+func naturalOrder(elements []object.Object) (func(i, j int) bool, *object.Error) {
+	if len(elements) == 0 {
+		return func(i, j int) bool { return false }, nil
+	}
+	switch elements[0].(type) {
+	case *object.Integer:
+		for _, el := range elements {
+			if _, ok := el.(*object.Integer); !ok {
+				return nil, newError(SORT_TYPE_MISMATCH, el.Type())
+			}
+		}
+		return func(i, j int) bool {
+			return elements[i].(*object.Integer).Value < elements[j].(*object.Integer).Value
+		}, nil
+	case *object.String:
+		for _, el := range elements {
+			if _, ok := el.(*object.String); !ok {
+				return nil, newError(SORT_TYPE_MISMATCH, el.Type())
+			}
+		}
+		return func(i, j int) bool {
+			return elements[i].(*object.String).Value < elements[j].(*object.String).Value
+		}, nil
+	}
+	return nil, newError(SORT_TYPE_MISMATCH, elements[0].Type())
+}
+
+// fn の結果が真になる最初の要素を返す。ない場合は null を返す
+func builtinFind(call object.CallFunction, args ...object.Object) object.Object {
+	arr, fn, err := arrayAndFunction("find", args)
+	if err != nil {
+		return err
+	}
+	for _, el := range arr.Elements {
+		result := call(fn, el)
+		if isError(result) {
+			return result
+		}
+		if isTruthy(result) {
+			return el
+		}
+	}
+	return NULL
+}
+
+// fn の結果が真になる要素が１つでもあるか
+func builtinAny(call object.CallFunction, args ...object.Object) object.Object {
+	arr, fn, err := arrayAndFunction("any", args)
+	if err != nil {
+		return err
+	}
+	for _, el := range arr.Elements {
+		result := call(fn, el)
+		if isError(result) {
+			return result
+		}
+		if isTruthy(result) {
+			return TRUE
+		}
+	}
+	return FALSE
+}
+
+// すべての要素で fn の結果が真になるか
+func builtinAll(call object.CallFunction, args ...object.Object) object.Object {
+	arr, fn, err := arrayAndFunction("all", args)
+	if err != nil {
+		return err
+	}
+	for _, el := range arr.Elements {
+		result := call(fn, el)
+		if isError(result) {
+			return result
+		}
+		if !isTruthy(result) {
+			return FALSE
+		}
+	}
+	return TRUE
+}
+
+// 2 つの配列の同じ位置の要素を組にした配列を返す。長さは短い方に合わせる
+func (in *Interpreter) builtinZip(args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return newError(BUILTIN_NUMBER_OF_ARGUMENT_ERROR, len(args), 2)
+	}
+	first, ok := args[0].(*object.Array)
+	if !ok {
+		return newError(BUILTIN_ARGUMENT_TYPE_MISMATCH, 1, "zip", object.ARRAY_OBJ, args[0].Type())
+	}
+	second, ok := args[1].(*object.Array)
+	if !ok {
+		return newError(BUILTIN_ARGUMENT_TYPE_MISMATCH, 2, "zip", object.ARRAY_OBJ, args[1].Type())
+	}
+	length := len(first.Elements)
+	if len(second.Elements) < length {
+		length = len(second.Elements)
+	}
+	// 組ごとの配列も数える
+	if err := in.allocate(2 * length); err != nil {
+		return err
+	}
+	pairs := make([]object.Object, length)
+	for i := range pairs {
+		pairs[i] = &object.Array{Elements: []object.Object{first.Elements[i], second.Elements[i]}}
+	}
+	return &object.Array{Elements: pairs}
+}
+
+// range(end), range(start, end), range(start, end, step) で整数の配列を返す
+// end は含まない
+func (in *Interpreter) builtinRange(args ...object.Object) object.Object {
+	if len(args) < 1 || len(args) > 3 {
+		return newError(BUILTIN_NUMBER_OF_ARGUMENT_RANGE_ERROR, len(args), 1, 3)
+	}
+	bounds := make([]int64, len(args))
+	for i, arg := range args {
+		integer, ok := arg.(*object.Integer)
+		if !ok {
+			return newError(BUILTIN_ARGUMENT_TYPE_MISMATCH, i+1, "range", object.INTEGER_OBJECT, arg.Type())
+		}
+		bounds[i] = integer.Value
+	}
+
+	start, end, step := int64(0), bounds[0], int64(1)
+	if len(bounds) >= 2 {
+		start, end = bounds[0], bounds[1]
+	}
+	if len(bounds) == 3 {
+		step = bounds[2]
+	}
+	if step == 0 {
+		return newError(RANGE_STEP_ZERO)
+	}
+	if err := in.allocate(rangeLength(start, end, step)); err != nil {
+		return err
+	}
+
+	elements := []object.Object{}
+	for i := start; (step > 0 && i < end) || (step < 0 && i > end); i += step {
+		elements = append(elements, &object.Integer{Value: i})
+		// 次の値が int64 に収まらない場合は終わる
+		if (step > 0 && i > math.MaxInt64-step) || (step < 0 && i < math.MinInt64-step) {
+			break
+		}
+	}
+	return &object.Array{Elements: elements}
+}
+
+// range(start, end, step) の要素数。int に収まらない場合は math.MaxInt を返す
+func rangeLength(start, end, step int64) int {
+	var width, stride uint64
+	switch {
+	case step > 0 && start < end:
+		width, stride = uint64(end)-uint64(start), uint64(step)
+	case step < 0 && start > end:
+		width, stride = uint64(start)-uint64(end), -uint64(step)
+	default:
+		return 0
+	}
+	n := (width-1)/stride + 1
+	if n > math.MaxInt {
+		return math.MaxInt
+	}
+	return int(n)
+}
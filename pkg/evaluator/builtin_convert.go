@@ -0,0 +1,93 @@
+package evaluator
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/oteto/gonkey/pkg/object"
+)
+
+const (
+	INTEGER_PARSE_ERROR = "could not parse %q as INTEGER"
+	BOOLEAN_PARSE_ERROR = "could not parse %q as BOOLEAN"
+)
+
+// 型の変換と判定をする組み込み関数
+func newConvertBuiltins() map[string]*object.Builtin {
+	return map[string]*object.Builtin{
+		"int":      {Fn: builtinInt},
+		"str":      {Fn: builtinStr},
+		"bool":     {Fn: builtinBool},
+		"type":     {Fn: builtinType},
+		"is_error": {Fn: builtinIsError, AcceptsErrors: true},
+	}
+}
+
+// 文字列は 10 進数として解釈し、真偽値は 1 と 0 にする
+func builtinInt(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return newError(BUILTIN_NUMBER_OF_ARGUMENT_ERROR, len(args), 1)
+	}
+	switch arg := args[0].(type) {
+	case *object.Integer:
+		return arg
+	case *object.String:
+		value, err := strconv.ParseInt(strings.TrimSpace(arg.Value), 10, 64)
+		if err != nil {
+			return newError(INTEGER_PARSE_ERROR, arg.Value)
+		}
+		return &object.Integer{Value: value}
+	case *object.Boolean:
+		if arg.Value {
+			return &object.Integer{Value: 1}
+		}
+		return &object.Integer{Value: 0}
+	default:
+		return newError(BUILTIN_ARGUMENT_TYPE_ERRROR, "int", arg.Type())
+	}
+}
+
+// puts と同じ表記の文字列にする
+func builtinStr(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return newError(BUILTIN_NUMBER_OF_ARGUMENT_ERROR, len(args), 1)
+	}
+	if str, ok := args[0].(*object.String); ok {
+		return str
+	}
+	return &object.String{Value: args[0].Inspect()}
+}
+
+// 文字列は "true" と "false" を解釈し、それ以外の値は if の条件と同じく真偽を判定する
+func builtinBool(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return newError(BUILTIN_NUMBER_OF_ARGUMENT_ERROR, len(args), 1)
+	}
+	str, ok := args[0].(*object.String)
+	if !ok {
+		return nativeBoolToBooleanObject(isTruthy(args[0]))
+	}
+	switch strings.TrimSpace(str.Value) {
+	case "true":
+		return TRUE
+	case "false":
+		return FALSE
+	}
+	return newError(BOOLEAN_PARSE_ERROR, str.Value)
+}
+
+// 値の型名を返す
+func builtinType(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return newError(BUILTIN_NUMBER_OF_ARGUMENT_ERROR, len(args), 1)
+	}
+	return &object.String{Value: string(args[0].Type())}
+}
+
+// 引数の評価でエラーが発生したか
+func builtinIsError(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return newError(BUILTIN_NUMBER_OF_ARGUMENT_ERROR, len(args), 1)
+	}
+	return nativeBoolToBooleanObject(isError(args[0]))
+}
@@ -0,0 +1,103 @@
+package evaluator
+
+import (
+	"errors"
+	"io/fs"
+
+	"github.com/oteto/gonkey/pkg/object"
+)
+
+const (
+	FILE_SYSTEM_DISABLED = "`%s` is not allowed: no file system is granted"
+	FILE_OPERATION_ERROR = "`%s` failed for %q: %s"
+)
+
+// WithFileSystem で渡されたファイルシステムを読み書きする組み込み関数
+// ファイルシステムが渡されていない場合はエラーを返す
+func (in *Interpreter) newFileBuiltins() map[string]*object.Builtin {
+	return map[string]*object.Builtin{
+		"read_file":  {Fn: in.builtinReadFile},
+		"write_file": {Fn: in.builtinWriteFile},
+		"list_dir":   {Fn: in.builtinListDir},
+		"exists":     {Fn: in.builtinExists},
+	}
+}
+
+// ファイルシステムと第１引数のパスを取り出す
+func (in *Interpreter) fileSystemAndPath(name string, args []object.Object, n int) (FileSystem, []string, *object.Error) {
+	if in.config.fileSystem == nil {
+		return nil, nil, newError(FILE_SYSTEM_DISABLED, name)
+	}
+	values, err := stringArguments(name, args, n)
+	if err != nil {
+		return nil, nil, err
+	}
+	return in.config.fileSystem, values, nil
+}
+
+// パスはエラーメッセージに含めるので、ホストの実際のパスではなくスクリプトが渡したパスを使う
+func fileOperationError(name, path string, err error) *object.Error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+	return newError(FILE_OPERATION_ERROR, name, path, err)
+}
+
+func (in *Interpreter) builtinReadFile(args ...object.Object) object.Object {
+	fsys, values, err := in.fileSystemAndPath("read_file", args, 1)
+	if err != nil {
+		return err
+	}
+	data, readErr := fsys.ReadFile(values[0])
+	if readErr != nil {
+		return fileOperationError("read_file", values[0], readErr)
+	}
+	return &object.String{Value: string(data)}
+}
+
+// write_file(path, content) でファイルを作るか、内容を置き換える
+func (in *Interpreter) builtinWriteFile(args ...object.Object) object.Object {
+	fsys, values, err := in.fileSystemAndPath("write_file", args, 2)
+	if err != nil {
+		return err
+	}
+	if writeErr := fsys.WriteFile(values[0], []byte(values[1])); writeErr != nil {
+		return fileOperationError("write_file", values[0], writeErr)
+	}
+	return NULL
+}
+
+// ディレクトリ内の名前を名前順の配列で返す
+func (in *Interpreter) builtinListDir(args ...object.Object) object.Object {
+	fsys, values, err := in.fileSystemAndPath("list_dir", args, 1)
+	if err != nil {
+		return err
+	}
+	entries, readErr := fsys.ReadDir(values[0])
+	if readErr != nil {
+		return fileOperationError("list_dir", values[0], readErr)
+	}
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	return stringArray(names)
+}
+
+// 存在しない場合は false を返し、許可されていないパスなどはエラーにする
+func (in *Interpreter) builtinExists(args ...object.Object) object.Object {
+	fsys, values, err := in.fileSystemAndPath("exists", args, 1)
+	if err != nil {
+		return err
+	}
+	_, statErr := fsys.Stat(values[0])
+	switch {
+	case statErr == nil:
+		return TRUE
+	case errors.Is(statErr, fs.ErrNotExist):
+		return FALSE
+	default:
+		return fileOperationError("exists", values[0], statErr)
+	}
+}
@@ -0,0 +1,77 @@
+package evaluator
+
+import (
+	"io"
+	"strings"
+
+	"github.com/oteto/gonkey/pkg/object"
+)
+
+const INPUT_READ_ERROR = "`%s` failed: %s"
+
+// WithStdin で渡された入力を読む組み込み関数
+// 呼び出しをまたいで読み進め、gets と read_line は入力の終わりで null を返す
+// 空行も真になるので、if (line) { ... } で入力の終わりを判定できる
+func (in *Interpreter) newInputBuiltins() map[string]*object.Builtin {
+	return map[string]*object.Builtin{
+		"gets":      {Fn: in.builtinGets},
+		"read_line": {Fn: in.builtinReadLine},
+		"read_all":  {Fn: in.builtinReadAll},
+	}
+}
+
+// 次の１行を末尾の改行を含めて読む。入力の終わりでは ok が false になる
+func (in *Interpreter) readLine(name string) (line string, ok bool, err *object.Error) {
+	line, readErr := in.input.ReadString('\n')
+	if readErr != nil && readErr != io.EOF {
+		return "", false, newError(INPUT_READ_ERROR, name, readErr)
+	}
+	if readErr == io.EOF && line == "" {
+		return "", false, nil
+	}
+	return line, true, nil
+}
+
+// 末尾の改行を含む１行を返す
+func (in *Interpreter) builtinGets(args ...object.Object) object.Object {
+	if len(args) != 0 {
+		return newError(BUILTIN_NUMBER_OF_ARGUMENT_ERROR, len(args), 0)
+	}
+	line, ok, err := in.readLine("gets")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return NULL
+	}
+	return &object.String{Value: line}
+}
+
+// 末尾の改行（\n または \r\n）を除いた１行を返す
+func (in *Interpreter) builtinReadLine(args ...object.Object) object.Object {
+	if len(args) != 0 {
+		return newError(BUILTIN_NUMBER_OF_ARGUMENT_ERROR, len(args), 0)
+	}
+	line, ok, err := in.readLine("read_line")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return NULL
+	}
+	line = strings.TrimSuffix(line, "\n")
+	line = strings.TrimSuffix(line, "\r")
+	return &object.String{Value: line}
+}
+
+// 残りの入力をすべて読む。入力の終わりでは空文字列を返す
+func (in *Interpreter) builtinReadAll(args ...object.Object) object.Object {
+	if len(args) != 0 {
+		return newError(BUILTIN_NUMBER_OF_ARGUMENT_ERROR, len(args), 0)
+	}
+	data, err := io.ReadAll(in.input)
+	if err != nil {
+		return newError(INPUT_READ_ERROR, "read_all", err)
+	}
+	return &object.String{Value: string(data)}
+}
@@ -0,0 +1,209 @@
+package evaluator
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/oteto/gonkey/pkg/object"
+)
+
+const (
+	JSON_PARSE_ERROR          = "invalid JSON at offset %d: %s"
+	JSON_NUMBER_NOT_INTEGER   = "invalid JSON at offset %d: number %s is not an INTEGER"
+	JSON_UNSUPPORTED_TYPE     = "cannot convert %s to JSON"
+	JSON_UNSUPPORTED_KEY_TYPE = "cannot convert HASH key of type %s to JSON, must be STRING"
+	JSON_INDENT_NEGATIVE      = "indent of `json_stringify` must not be negative, got %d"
+)
+
+// JSON の文字列と値を相互に変換する組み込み関数
+// オブジェクトのキーは文書に現れた順に保ち、数値は整数だけを扱う
+func (in *Interpreter) newJSONBuiltins() map[string]*object.Builtin {
+	return map[string]*object.Builtin{
+		"json_parse":     {Fn: in.builtinJSONParse},
+		"json_stringify": {Fn: builtinJSONStringify},
+	}
+}
+
+func (in *Interpreter) builtinJSONParse(args ...object.Object) object.Object {
+	values, err := stringArguments("json_parse", args, 1)
+	if err != nil {
+		return err
+	}
+	src := values[0]
+
+	// 構文の誤りは先に検査し、誤りのあるバイトの位置（0 始まり）を含むエラーにする
+	var raw json.RawMessage
+	if err := json.Unmarshal([]byte(src), &raw); err != nil {
+		var syntaxErr *json.SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			return newError(JSON_PARSE_ERROR, 0, err.Error())
+		}
+		// Offset は読み終えたバイト数なので、途中で終わった場合以外は誤りの文字の次を指す
+		offset := syntaxErr.Offset
+		if offset > 0 && offset <= int64(len(src)) && syntaxErr.Error() != "unexpected end of JSON input" {
+			offset--
+		}
+		return newError(JSON_PARSE_ERROR, offset, syntaxErr.Error())
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	// raw は src の前後の空白を含まない
+	offset := int64(strings.Index(src, string(raw)))
+	return in.decodeJSON(dec, offset)
+}
+
+// 構文が正しい JSON から次の値を読む
+// キーの順序を保つため、map を経由せずにトークンから組み立てる
+// 配列の要素とハッシュの組は１つずつ生成数の上限に数える
+func (in *Interpreter) decodeJSON(dec *json.Decoder, offset int64) object.Object {
+	token, _ := dec.Token()
+
+	switch token := token.(type) {
+	case json.Delim:
+		if token == '[' {
+			elements := []object.Object{}
+			for dec.More() {
+				if err := in.allocate(1); err != nil {
+					return err
+				}
+				el := in.decodeJSON(dec, offset)
+				if isError(el) {
+					return el
+				}
+				elements = append(elements, el)
+			}
+			dec.Token()
+			return &object.Array{Elements: elements}
+		}
+
+		hash := object.NewHash(0)
+		for dec.More() {
+			if err := in.allocate(1); err != nil {
+				return err
+			}
+			keyToken, _ := dec.Token()
+			key := &object.String{Value: keyToken.(string)}
+			value := in.decodeJSON(dec, offset)
+			if isError(value) {
+				return value
+			}
+			hash.Set(key.HashKey(), object.HashPair{Key: key, Value: value})
+		}
+		dec.Token()
+		return hash
+	case json.Number:
+		value, err := token.Int64()
+		if err != nil {
+			return newError(JSON_NUMBER_NOT_INTEGER, offset+dec.InputOffset()-int64(len(token)), token)
+		}
+		return &object.Integer{Value: value}
+	case string:
+		return &object.String{Value: token}
+	case bool:
+		return nativeBoolToBooleanObject(token)
+	default:
+		return NULL
+	}
+}
+
+// JavaScript の JSON.stringify と同じく、字下げは 10 個の空白か、文字列の先頭 10 文字までにする
+const maxJSONIndent = 10
+
+// json_stringify(value) は空白なしで、json_stringify(value, indent) は
+// indent 個の空白か indent の文字列で字下げした JSON の文字列を返す
+func builtinJSONStringify(args ...object.Object) object.Object {
+	if len(args) != 1 && len(args) != 2 {
+		return newError(BUILTIN_NUMBER_OF_ARGUMENT_RANGE_ERROR, len(args), 1, 2)
+	}
+
+	indent := ""
+	if len(args) == 2 {
+		switch arg := args[1].(type) {
+		case *object.Integer:
+			if arg.Value < 0 {
+				return newError(JSON_INDENT_NEGATIVE, arg.Value)
+			}
+			indent = strings.Repeat(" ", int(min64(arg.Value, maxJSONIndent)))
+		case *object.String:
+			indent = arg.Value
+			if runes := []rune(indent); len(runes) > maxJSONIndent {
+				indent = string(runes[:maxJSONIndent])
+			}
+		default:
+			return newError(BUILTIN_ARGUMENT_TYPE_MISMATCH, 2, "json_stringify", object.INTEGER_OBJECT+" or "+object.STRING_OBJECT, arg.Type())
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := encodeJSON(&buf, args[0]); err != nil {
+		return err
+	}
+	if indent == "" {
+		return &object.String{Value: buf.String()}
+	}
+
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, buf.Bytes(), "", indent); err != nil {
+		return newError(JSON_PARSE_ERROR, 0, err.Error())
+	}
+	return &object.String{Value: indented.String()}
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func encodeJSON(buf *bytes.Buffer, obj object.Object) *object.Error {
+	switch obj := obj.(type) {
+	case *object.Integer, *object.Boolean, *object.Null:
+		buf.WriteString(obj.Inspect())
+	case *object.String:
+		encodeJSONString(buf, obj.Value)
+	case *object.Array:
+		buf.WriteByte('[')
+		for i, el := range obj.Elements {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encodeJSON(buf, el); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case *object.Hash:
+		buf.WriteByte('{')
+		for i, pair := range obj.OrderedPairs() {
+			key, ok := pair.Key.(*object.String)
+			if !ok {
+				return newError(JSON_UNSUPPORTED_KEY_TYPE, pair.Key.Type())
+			}
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			encodeJSONString(buf, key.Value)
+			buf.WriteByte(':')
+			if err := encodeJSON(buf, pair.Value); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return newError(JSON_UNSUPPORTED_TYPE, obj.Type())
+	}
+	return nil
+}
+
+// HTML の特殊文字はエスケープしない
+func encodeJSONString(buf *bytes.Buffer, s string) {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	// Encode が末尾に付ける改行を取り除く
+	buf.Truncate(buf.Len() - 1)
+}
@@ -0,0 +1,255 @@
+package evaluator
+
+import (
+	"math"
+
+	"github.com/oteto/gonkey/pkg/object"
+)
+
+const (
+	INTEGER_OVERFLOW         = "integer overflow in `%s`"
+	NEGATIVE_ARGUMENT        = "argument to `%s` must not be negative, got %d"
+	DIVISION_BY_ZERO         = "division by zero in `%s`"
+	CLAMP_BOUNDS_REVERSED    = "lower bound of `clamp` must not exceed upper bound, got %d > %d"
+	RANDOM_RANGE_EMPTY       = "range of `random` must not be empty, got [%d, %d)"
+	MIN_MAX_EMPTY            = "`%s` requires at least one INTEGER"
+	MIN_MAX_ELEMENT_MISMATCH = "element %d of `%s` must be INTEGER, got %s"
+)
+
+// math.MaxInt64 の平方根の整数部分
+const maxSqrt = 3037000499
+
+// math.abs(-1) のように呼び出す整数の数学関数
+func (in *Interpreter) newMathModule() *object.Hash {
+	return newModule(map[string]*object.Builtin{
+		"abs":    {Fn: builtinAbs},
+		"min":    {Fn: builtinMin},
+		"max":    {Fn: builtinMax},
+		"pow":    {Fn: builtinPow},
+		"sqrt":   {Fn: builtinSqrt},
+		"floor":  {Fn: builtinFloor},
+		"ceil":   {Fn: builtinCeil},
+		"clamp":  {Fn: builtinClamp},
+		"random": {Fn: in.builtinRandom},
+	})
+}
+
+// n 個の整数の引数を取り出す
+func integerArguments(name string, args []object.Object, n int) ([]int64, *object.Error) {
+	if len(args) != n {
+		return nil, newError(BUILTIN_NUMBER_OF_ARGUMENT_ERROR, len(args), n)
+	}
+	values := make([]int64, n)
+	for i, arg := range args {
+		integer, ok := arg.(*object.Integer)
+		if !ok {
+			return nil, newError(BUILTIN_ARGUMENT_TYPE_MISMATCH, i+1, name, object.INTEGER_OBJECT, arg.Type())
+		}
+		values[i] = integer.Value
+	}
+	return values, nil
+}
+
+func builtinAbs(args ...object.Object) object.Object {
+	values, err := integerArguments("abs", args, 1)
+	if err != nil {
+		return err
+	}
+	switch x := values[0]; {
+	case x == math.MinInt64:
+		return newError(INTEGER_OVERFLOW, "abs")
+	case x < 0:
+		return &object.Integer{Value: -x}
+	default:
+		return args[0]
+	}
+}
+
+// min(1, 2, 3) のように複数の整数か、min([1, 2, 3]) のように整数の配列を受け取る
+func builtinMin(args ...object.Object) object.Object {
+	return extremum("min", args, func(a, b int64) bool { return a < b })
+}
+
+func builtinMax(args ...object.Object) object.Object {
+	return extremum("max", args, func(a, b int64) bool { return a > b })
+}
+
+// better(a, b) が true の場合に a を選ぶ
+func extremum(name string, args []object.Object, better func(a, b int64) bool) object.Object {
+	if len(args) == 1 {
+		if arr, ok := args[0].(*object.Array); ok {
+			args = arr.Elements
+		}
+	}
+	if len(args) == 0 {
+		return newError(MIN_MAX_EMPTY, name)
+	}
+
+	var result *object.Integer
+	for i, arg := range args {
+		integer, ok := arg.(*object.Integer)
+		if !ok {
+			return newError(MIN_MAX_ELEMENT_MISMATCH, i, name, arg.Type())
+		}
+		if result == nil || better(integer.Value, result.Value) {
+			result = integer
+		}
+	}
+	return result
+}
+
+// exp は 0 以上
+func builtinPow(args ...object.Object) object.Object {
+	values, err := integerArguments("pow", args, 2)
+	if err != nil {
+		return err
+	}
+	base, exp := values[0], values[1]
+	if exp < 0 {
+		return newError(NEGATIVE_ARGUMENT, "pow", exp)
+	}
+
+	result := int64(1)
+	for exp > 0 {
+		if exp&1 == 1 {
+			if multiplyOverflows(result, base) {
+				return newError(INTEGER_OVERFLOW, "pow")
+			}
+			result *= base
+		}
+		exp >>= 1
+		// 残りの指数があれば base の２乗は必ず結果に掛かる
+		if exp > 0 {
+			if multiplyOverflows(base, base) {
+				return newError(INTEGER_OVERFLOW, "pow")
+			}
+			base *= base
+		}
+	}
+	return &object.Integer{Value: result}
+}
+
+func multiplyOverflows(a, b int64) bool {
+	if a == 0 || b == 0 {
+		return false
+	}
+	if (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+		return true
+	}
+	return a*b/b != a
+}
+
+// 平方根の整数部分を返す
+func builtinSqrt(args ...object.Object) object.Object {
+	values, err := integerArguments("sqrt", args, 1)
+	if err != nil {
+		return err
+	}
+	n := values[0]
+	if n < 0 {
+		return newError(NEGATIVE_ARGUMENT, "sqrt", n)
+	}
+	// float64 の誤差を整数で補正する
+	root := int64(math.Sqrt(float64(n)))
+	if root > maxSqrt {
+		root = maxSqrt
+	}
+	for root*root > n {
+		root--
+	}
+	for root < maxSqrt && (root+1)*(root+1) <= n {
+		root++
+	}
+	return &object.Integer{Value: root}
+}
+
+// floor(a, b) は a / b を負の無限大の方向に丸める
+// 整数しかないので floor(x) は x をそのまま返す
+func builtinFloor(args ...object.Object) object.Object {
+	return roundedDivision("floor", args, func(r, b int64) bool { return r != 0 && (r < 0) != (b < 0) }, -1)
+}
+
+// ceil(a, b) は a / b を正の無限大の方向に丸める
+// 整数しかないので ceil(x) は x をそのまま返す
+func builtinCeil(args ...object.Object) object.Object {
+	return roundedDivision("ceil", args, func(r, b int64) bool { return r != 0 && (r < 0) == (b < 0) }, 1)
+}
+
+// 0 の方向に丸めた商を、adjust(余り, 除数) が true の場合に delta だけ補正する
+func roundedDivision(name string, args []object.Object, adjust func(r, b int64) bool, delta int64) object.Object {
+	if len(args) == 1 {
+		if _, err := integerArguments(name, args, 1); err != nil {
+			return err
+		}
+		return args[0]
+	}
+	values, err := integerArguments(name, args, 2)
+	if err != nil {
+		return err
+	}
+	a, b := values[0], values[1]
+	if b == 0 {
+		return newError(DIVISION_BY_ZERO, name)
+	}
+	if a == math.MinInt64 && b == -1 {
+		return newError(INTEGER_OVERFLOW, name)
+	}
+	q, r := a/b, a%b
+	if adjust(r, b) {
+		q += delta
+	}
+	return &object.Integer{Value: q}
+}
+
+// clamp(x, lo, hi) で x を lo 以上 hi 以下に収める
+func builtinClamp(args ...object.Object) object.Object {
+	values, err := integerArguments("clamp", args, 3)
+	if err != nil {
+		return err
+	}
+	x, lo, hi := values[0], values[1], values[2]
+	if lo > hi {
+		return newError(CLAMP_BOUNDS_REVERSED, lo, hi)
+	}
+	switch {
+	case x < lo:
+		return args[1]
+	case x > hi:
+		return args[2]
+	default:
+		return args[0]
+	}
+}
+
+// random() は 0 以上の整数、random(n) は 0 以上 n 未満、random(lo, hi) は lo 以上 hi 未満の整数を返す
+// WithRandomSeed で種を指定すると、同じ種では同じ順に同じ値を返す
+func (in *Interpreter) builtinRandom(args ...object.Object) object.Object {
+	if len(args) > 2 {
+		return newError(BUILTIN_NUMBER_OF_ARGUMENT_RANGE_ERROR, len(args), 0, 2)
+	}
+	if len(args) == 0 {
+		return &object.Integer{Value: in.random.Int63()}
+	}
+
+	values, err := integerArguments("random", args, len(args))
+	if err != nil {
+		return err
+	}
+	lo, hi := int64(0), values[0]
+	if len(values) == 2 {
+		lo, hi = values[0], values[1]
+	}
+	if lo >= hi {
+		return newError(RANDOM_RANGE_EMPTY, lo, hi)
+	}
+	// 幅が int64 に収まらない場合は範囲に入るまで引き直す
+	width := uint64(hi) - uint64(lo)
+	if width > math.MaxInt64 {
+		for {
+			if n := int64(in.random.Uint64()); lo <= n && n < hi {
+				return &object.Integer{Value: n}
+			}
+		}
+	}
+	return &object.Integer{Value: lo + in.random.Int63n(int64(width))}
+}
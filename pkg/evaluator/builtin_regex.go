@@ -0,0 +1,225 @@
+package evaluator
+
+import (
+	"regexp"
+	"sync"
+
+	"github.com/oteto/gonkey/pkg/object"
+)
+
+const (
+	REGEX_COMPILE_ERROR    = "invalid regular expression %q: %s"
+	REPLACE_RESULT_TYPE    = "replacement function of `replace_all` must return STRING, got %s"
+	REGEX_ARGUMENT_PATTERN = "REGEX or STRING"
+)
+
+// キャッシュするパターンの数。超えた場合はキャッシュを空にする
+const maxCachedPatterns = 256
+
+// 文字列で渡されたパターンのコンパイル結果を使い回す
+type regexCache struct {
+	mu       sync.Mutex
+	patterns map[string]*regexp.Regexp
+}
+
+func newRegexCache() *regexCache {
+	return &regexCache{patterns: map[string]*regexp.Regexp{}}
+}
+
+func (c *regexCache) compile(pattern string) (*regexp.Regexp, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if re, ok := c.patterns[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.patterns) >= maxCachedPatterns {
+		c.patterns = map[string]*regexp.Regexp{}
+	}
+	c.patterns[pattern] = re
+	return re, nil
+}
+
+// 正規表現を扱う組み込み関数
+// パターンには regex で作った値か、Go の regexp の構文の文字列を渡す
+// 第１引数がパターンなので re.match(s) のようにも呼び出せる
+func (in *Interpreter) newRegexBuiltins() map[string]*object.Builtin {
+	return map[string]*object.Builtin{
+		"regex":       {Fn: in.builtinRegex},
+		"match":       {Fn: in.builtinMatch},
+		"find_all":    {Fn: in.builtinFindAll},
+		"capture":     {Fn: in.builtinCapture},
+		"replace_all": {HigherOrderFn: in.builtinReplaceAll},
+	}
+}
+
+// 第１引数のパターンと第２引数の文字列を取り出す
+func (in *Interpreter) patternAndString(name string, args []object.Object) (*regexp.Regexp, string, *object.Error) {
+	re, err := in.pattern(name, args[0])
+	if err != nil {
+		return nil, "", err
+	}
+	str, ok := args[1].(*object.String)
+	if !ok {
+		return nil, "", newError(BUILTIN_ARGUMENT_TYPE_MISMATCH, 2, name, object.STRING_OBJECT, args[1].Type())
+	}
+	return re, str.Value, nil
+}
+
+func (in *Interpreter) pattern(name string, arg object.Object) (*regexp.Regexp, *object.Error) {
+	switch arg := arg.(type) {
+	case *object.Regex:
+		return arg.Regexp, nil
+	case *object.String:
+		re, err := in.regexps.compile(arg.Value)
+		if err != nil {
+			return nil, newError(REGEX_COMPILE_ERROR, arg.Value, err)
+		}
+		return re, nil
+	}
+	return nil, newError(BUILTIN_ARGUMENT_TYPE_MISMATCH, 1, name, REGEX_ARGUMENT_PATTERN, arg.Type())
+}
+
+// 文字列のパターンをコンパイルし、構文の誤りをその場で報告する
+func (in *Interpreter) builtinRegex(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return newError(BUILTIN_NUMBER_OF_ARGUMENT_ERROR, len(args), 1)
+	}
+	if re, ok := args[0].(*object.Regex); ok {
+		return re
+	}
+	if _, ok := args[0].(*object.String); !ok {
+		return newError(BUILTIN_ARGUMENT_TYPE_MISMATCH, 1, "regex", object.STRING_OBJECT, args[0].Type())
+	}
+	re, err := in.pattern("regex", args[0])
+	if err != nil {
+		return err
+	}
+	return &object.Regex{Regexp: re}
+}
+
+// 文字列のどこかにパターンが現れるか
+func (in *Interpreter) builtinMatch(args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return newError(BUILTIN_NUMBER_OF_ARGUMENT_ERROR, len(args), 2)
+	}
+	re, str, err := in.patternAndString("match", args)
+	if err != nil {
+		return err
+	}
+	return nativeBoolToBooleanObject(re.MatchString(str))
+}
+
+// 重ならないすべての一致の配列を返す
+func (in *Interpreter) builtinFindAll(args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return newError(BUILTIN_NUMBER_OF_ARGUMENT_ERROR, len(args), 2)
+	}
+	re, str, err := in.patternAndString("find_all", args)
+	if err != nil {
+		return err
+	}
+	matches := re.FindAllStringIndex(str, -1)
+	if err := in.allocate(len(matches)); err != nil {
+		return err
+	}
+	elements := make([]object.Object, len(matches))
+	for i, m := range matches {
+		elements[i] = &object.String{Value: str[m[0]:m[1]]}
+	}
+	return &object.Array{Elements: elements}
+}
+
+// 最初の一致の [全体, グループ 1, グループ 2, ...] を返す。一致しない場合は null を返す
+// 一致に使われなかったグループは null になる
+func (in *Interpreter) builtinCapture(args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return newError(BUILTIN_NUMBER_OF_ARGUMENT_ERROR, len(args), 2)
+	}
+	re, str, err := in.patternAndString("capture", args)
+	if err != nil {
+		return err
+	}
+	indexes := re.FindStringSubmatchIndex(str)
+	if indexes == nil {
+		return NULL
+	}
+	groups := make([]object.Object, len(indexes)/2)
+	for i := range groups {
+		start, end := indexes[2*i], indexes[2*i+1]
+		if start < 0 {
+			groups[i] = NULL
+			continue
+		}
+		groups[i] = &object.String{Value: str[start:end]}
+	}
+	return &object.Array{Elements: groups}
+}
+
+// replace_all(pattern, s, repl) で一致をすべて置き換える
+// repl が文字列の場合は $1 や ${name} をグループに展開し、
+// 関数の場合は一致した文字列を渡して返された文字列に置き換える
+func (in *Interpreter) builtinReplaceAll(call object.CallFunction, args ...object.Object) object.Object {
+	if len(args) != 3 {
+		return newError(BUILTIN_NUMBER_OF_ARGUMENT_ERROR, len(args), 3)
+	}
+	re, str, err := in.patternAndString("replace_all", args[:2])
+	if err != nil {
+		return err
+	}
+
+	switch repl := args[2].(type) {
+	case *object.String:
+		if err := checkStringLength(replacedLength(re, str, repl.Value)); err != nil {
+			return err
+		}
+		return &object.String{Value: re.ReplaceAllString(str, repl.Value)}
+	default:
+		if !isCallable(repl) {
+			return newError(BUILTIN_ARGUMENT_TYPE_MISMATCH, 3, "replace_all", object.STRING_OBJECT, repl.Type())
+		}
+		// エラーが起きた場合は残りの置き換えを打ち切る
+		var replaceErr object.Object
+		length := int64(len(str))
+		result := re.ReplaceAllStringFunc(str, func(match string) string {
+			if replaceErr != nil {
+				return match
+			}
+			replaced := call(repl, &object.String{Value: match})
+			s, ok := replaced.(*object.String)
+			if !ok {
+				replaceErr = replaced
+				if !isError(replaced) {
+					replaceErr = newError(REPLACE_RESULT_TYPE, replaced.Type())
+				}
+				return match
+			}
+			length += int64(len(s.Value) - len(match))
+			if err := checkStringLength(length); err != nil {
+				replaceErr = err
+				return match
+			}
+			return s.Value
+		})
+		if replaceErr != nil {
+			return replaceErr
+		}
+		return &object.String{Value: result}
+	}
+}
+
+// 文字列の repl で一致をすべて置き換えた結果のバイト数
+// 置き換えた文字列を作る前に長さの上限を確認するために使う
+func replacedLength(re *regexp.Regexp, str, repl string) int64 {
+	length := int64(len(str))
+	var expanded []byte
+	for _, m := range re.FindAllStringSubmatchIndex(str, -1) {
+		expanded = re.ExpandString(expanded[:0], repl, str, m)
+		length += int64(len(expanded) - (m[1] - m[0]))
+	}
+	return length
+}
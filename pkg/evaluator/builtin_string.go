@@ -0,0 +1,286 @@
+package evaluator
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/oteto/gonkey/pkg/object"
+)
+
+const (
+	JOIN_ELEMENT_TYPE_MISMATCH = "element %d of `join` must be STRING, got %s"
+	REPEAT_COUNT_NEGATIVE      = "count of `repeat` must not be negative, got %d"
+	REPEAT_RESULT_TOO_LARGE    = "result of `repeat` too large"
+	STRING_TOO_LARGE           = "string too large: %d bytes (max %d)"
+	FORMAT_WIDTH_TOO_LARGE     = "total width and precision of `format` too large (max %d)"
+)
+
+// スクリプトが作れる文字列の長さ（バイト数）の上限
+// オブジェクトの数を数える WithMaxAllocations では１つの巨大な文字列を防げないので、長さを別に制限する
+const maxStringLength = 1 << 24
+
+// 長さ n の文字列を作れるかどうか
+func checkStringLength(n int64) *object.Error {
+	if n > maxStringLength {
+		return newError(STRING_TOO_LARGE, n, maxStringLength)
+	}
+	return nil
+}
+
+// 文字列を操作する組み込み関数
+// 位置と長さは len や添字と同じくバイト単位で、chars と upper, lower は文字単位で扱う
+// 配列を作る split と chars は要素の数を割り当ての上限に数える
+func (in *Interpreter) newStringBuiltins() map[string]*object.Builtin {
+	return map[string]*object.Builtin{
+		"split":       {Fn: in.builtinSplit},
+		"join":        {Fn: builtinJoin},
+		"trim":        {Fn: builtinTrim},
+		"upper":       {Fn: builtinUpper},
+		"lower":       {Fn: builtinLower},
+		"contains":    {Fn: builtinContains},
+		"replace":     {Fn: builtinReplace},
+		"starts_with": {Fn: builtinStartsWith},
+		"ends_with":   {Fn: builtinEndsWith},
+		"index_of":    {Fn: builtinIndexOf},
+		"repeat":      {Fn: builtinRepeat},
+		"chars":       {Fn: in.builtinChars},
+		"format":      {Fn: builtinFormat},
+	}
+}
+
+// n 個の文字列の引数を取り出す
+func stringArguments(name string, args []object.Object, n int) ([]string, *object.Error) {
+	if len(args) != n {
+		return nil, newError(BUILTIN_NUMBER_OF_ARGUMENT_ERROR, len(args), n)
+	}
+	values := make([]string, n)
+	for i, arg := range args {
+		str, ok := arg.(*object.String)
+		if !ok {
+			return nil, newError(BUILTIN_ARGUMENT_TYPE_MISMATCH, i+1, name, object.STRING_OBJECT, arg.Type())
+		}
+		values[i] = str.Value
+	}
+	return values, nil
+}
+
+func stringArray(values []string) *object.Array {
+	elements := make([]object.Object, len(values))
+	for i, v := range values {
+		elements[i] = &object.String{Value: v}
+	}
+	return &object.Array{Elements: elements}
+}
+
+// sep で区切った文字列の配列を返す。sep が空文字列の場合は１文字ずつに分ける
+func (in *Interpreter) builtinSplit(args ...object.Object) object.Object {
+	values, err := stringArguments("split", args, 2)
+	if err != nil {
+		return err
+	}
+	n := utf8.RuneCountInString(values[0])
+	if values[1] != "" {
+		n = strings.Count(values[0], values[1]) + 1
+	}
+	if err := in.allocate(n); err != nil {
+		return err
+	}
+	return stringArray(strings.Split(values[0], values[1]))
+}
+
+func builtinJoin(args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return newError(BUILTIN_NUMBER_OF_ARGUMENT_ERROR, len(args), 2)
+	}
+	arr, ok := args[0].(*object.Array)
+	if !ok {
+		return newError(BUILTIN_ARGUMENT_TYPE_MISMATCH, 1, "join", object.ARRAY_OBJ, args[0].Type())
+	}
+	sep, ok := args[1].(*object.String)
+	if !ok {
+		return newError(BUILTIN_ARGUMENT_TYPE_MISMATCH, 2, "join", object.STRING_OBJECT, args[1].Type())
+	}
+	values := make([]string, len(arr.Elements))
+	length := int64(0)
+	for i, el := range arr.Elements {
+		str, ok := el.(*object.String)
+		if !ok {
+			return newError(JOIN_ELEMENT_TYPE_MISMATCH, i, el.Type())
+		}
+		values[i] = str.Value
+		length += int64(len(str.Value))
+		if i > 0 {
+			length += int64(len(sep.Value))
+		}
+		if err := checkStringLength(length); err != nil {
+			return err
+		}
+	}
+	return &object.String{Value: strings.Join(values, sep.Value)}
+}
+
+// 前後の空白を取り除く
+func builtinTrim(args ...object.Object) object.Object {
+	values, err := stringArguments("trim", args, 1)
+	if err != nil {
+		return err
+	}
+	return &object.String{Value: strings.TrimSpace(values[0])}
+}
+
+func builtinUpper(args ...object.Object) object.Object {
+	values, err := stringArguments("upper", args, 1)
+	if err != nil {
+		return err
+	}
+	return &object.String{Value: strings.ToUpper(values[0])}
+}
+
+func builtinLower(args ...object.Object) object.Object {
+	values, err := stringArguments("lower", args, 1)
+	if err != nil {
+		return err
+	}
+	return &object.String{Value: strings.ToLower(values[0])}
+}
+
+func builtinContains(args ...object.Object) object.Object {
+	values, err := stringArguments("contains", args, 2)
+	if err != nil {
+		return err
+	}
+	return nativeBoolToBooleanObject(strings.Contains(values[0], values[1]))
+}
+
+// replace(s, old, new) で old をすべて new に置き換える
+func builtinReplace(args ...object.Object) object.Object {
+	values, err := stringArguments("replace", args, 3)
+	if err != nil {
+		return err
+	}
+	n := strings.Count(values[0], values[1])
+	if err := checkStringLength(int64(len(values[0])) + int64(n)*int64(len(values[2])-len(values[1]))); err != nil {
+		return err
+	}
+	return &object.String{Value: strings.ReplaceAll(values[0], values[1], values[2])}
+}
+
+func builtinStartsWith(args ...object.Object) object.Object {
+	values, err := stringArguments("starts_with", args, 2)
+	if err != nil {
+		return err
+	}
+	return nativeBoolToBooleanObject(strings.HasPrefix(values[0], values[1]))
+}
+
+func builtinEndsWith(args ...object.Object) object.Object {
+	values, err := stringArguments("ends_with", args, 2)
+	if err != nil {
+		return err
+	}
+	return nativeBoolToBooleanObject(strings.HasSuffix(values[0], values[1]))
+}
+
+// 最初に現れる位置を返す。ない場合は -1 を返す
+func builtinIndexOf(args ...object.Object) object.Object {
+	values, err := stringArguments("index_of", args, 2)
+	if err != nil {
+		return err
+	}
+	return &object.Integer{Value: int64(strings.Index(values[0], values[1]))}
+}
+
+func builtinRepeat(args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return newError(BUILTIN_NUMBER_OF_ARGUMENT_ERROR, len(args), 2)
+	}
+	str, ok := args[0].(*object.String)
+	if !ok {
+		return newError(BUILTIN_ARGUMENT_TYPE_MISMATCH, 1, "repeat", object.STRING_OBJECT, args[0].Type())
+	}
+	count, ok := args[1].(*object.Integer)
+	if !ok {
+		return newError(BUILTIN_ARGUMENT_TYPE_MISMATCH, 2, "repeat", object.INTEGER_OBJECT, args[1].Type())
+	}
+	if count.Value < 0 {
+		return newError(REPEAT_COUNT_NEGATIVE, count.Value)
+	}
+	if count.Value > 0 && int64(len(str.Value)) > maxStringLength/count.Value {
+		return newError(REPEAT_RESULT_TOO_LARGE)
+	}
+	return &object.String{Value: strings.Repeat(str.Value, int(count.Value))}
+}
+
+// １文字ずつの文字列の配列を返す
+func (in *Interpreter) builtinChars(args ...object.Object) object.Object {
+	values, err := stringArguments("chars", args, 1)
+	if err != nil {
+		return err
+	}
+	if err := in.allocate(utf8.RuneCountInString(values[0])); err != nil {
+		return err
+	}
+	chars := []string{}
+	for _, r := range values[0] {
+		chars = append(chars, string(r))
+	}
+	return stringArray(chars)
+}
+
+// 書式の指定子の幅と精度（%-08.3d の 08 と 3）
+var formatWidthPattern = regexp.MustCompile(`%[-+# 0]*(?:\[\d+\])?(\d*)(?:\.(?:\[\d+\])?(\d*))?`)
+
+// 書式のすべての幅と精度の合計が文字列の長さの上限以下か
+// fmt.Sprintf は大きな幅の文字列をすぐに作ってしまうので、書式を適用する前に確認する
+func checkFormatWidths(format string) *object.Error {
+	total := int64(0)
+	for _, m := range formatWidthPattern.FindAllStringSubmatch(format, -1) {
+		for _, digits := range m[1:] {
+			if digits == "" {
+				continue
+			}
+			n, err := strconv.ParseInt(digits, 10, 64)
+			if err != nil || n > maxStringLength-total {
+				return newError(FORMAT_WIDTH_TOO_LARGE, maxStringLength)
+			}
+			total += n
+		}
+	}
+	return nil
+}
+
+// Go の fmt.Sprintf と同じ書式で文字列を作る
+// 整数・文字列・真偽値以外の値は Inspect した文字列として渡す
+func builtinFormat(args ...object.Object) object.Object {
+	if len(args) < 1 {
+		return newError(BUILTIN_NUMBER_OF_ARGUMENT_ERROR, len(args), 1)
+	}
+	format, ok := args[0].(*object.String)
+	if !ok {
+		return newError(BUILTIN_ARGUMENT_TYPE_MISMATCH, 1, "format", object.STRING_OBJECT, args[0].Type())
+	}
+	values := make([]interface{}, len(args)-1)
+	for i, arg := range args[1:] {
+		switch arg := arg.(type) {
+		case *object.Integer:
+			values[i] = arg.Value
+		case *object.String:
+			values[i] = arg.Value
+		case *object.Boolean:
+			values[i] = arg.Value
+		default:
+			values[i] = arg.Inspect()
+		}
+	}
+	if err := checkFormatWidths(format.Value); err != nil {
+		return err
+	}
+	result := fmt.Sprintf(format.Value, values...)
+	if err := checkStringLength(int64(len(result))); err != nil {
+		return err
+	}
+	return &object.String{Value: result}
+}
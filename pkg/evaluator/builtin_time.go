@@ -0,0 +1,140 @@
+package evaluator
+
+import (
+	"math"
+	"time"
+
+	"github.com/oteto/gonkey/pkg/object"
+)
+
+const (
+	TIME_PARSE_ERROR      = "could not parse %q as time with layout %q"
+	DURATION_PARSE_ERROR  = "could not parse %q as duration"
+	DURATION_OUT_OF_RANGE = "duration %d ms out of range"
+	SLEEP_NEGATIVE        = "duration of `sleep` must not be negative, got %d"
+	SLEEP_FAILED          = "`sleep` failed: %s"
+)
+
+// format_time と parse_time のデフォルトの書式（ミリ秒までの RFC 3339）
+const defaultTimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// 時刻と期間を扱う組み込み関数
+// 時刻は Unix 時間のミリ秒、期間はミリ秒の整数なので、now() + duration("5m") のように計算できる
+// 時刻は WithClock で渡された時計から取得する
+func (in *Interpreter) newTimeBuiltins() map[string]*object.Builtin {
+	return map[string]*object.Builtin{
+		"now":             {Fn: in.builtinNow},
+		"sleep":           {Fn: in.builtinSleep},
+		"format_time":     {Fn: builtinFormatTime},
+		"parse_time":      {Fn: builtinParseTime},
+		"duration":        {Fn: builtinDuration},
+		"format_duration": {Fn: builtinFormatDuration},
+	}
+}
+
+func (in *Interpreter) builtinNow(args ...object.Object) object.Object {
+	if len(args) != 0 {
+		return newError(BUILTIN_NUMBER_OF_ARGUMENT_ERROR, len(args), 0)
+	}
+	return &object.Integer{Value: in.config.clock.Now().UnixMilli()}
+}
+
+// sleep(ms) で ms ミリ秒待つ
+// 待っている間に実行時間の上限を超えるか、キャンセルされた場合はその時点で止まる
+func (in *Interpreter) builtinSleep(args ...object.Object) object.Object {
+	values, err := integerArguments("sleep", args, 1)
+	if err != nil {
+		return err
+	}
+	ms := values[0]
+	if ms < 0 {
+		return newError(SLEEP_NEGATIVE, ms)
+	}
+	d, err := milliseconds(ms)
+	if err != nil {
+		return err
+	}
+	ctx := in.context()
+	if sleepErr := in.config.clock.Sleep(ctx, d); sleepErr != nil {
+		if ctx.Err() != nil {
+			return contextError(ctx)
+		}
+		return newError(SLEEP_FAILED, sleepErr)
+	}
+	return NULL
+}
+
+func milliseconds(ms int64) (time.Duration, *object.Error) {
+	if ms > math.MaxInt64/int64(time.Millisecond) || ms < math.MinInt64/int64(time.Millisecond) {
+		return 0, newError(DURATION_OUT_OF_RANGE, ms)
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// format_time(t) は UTC の RFC 3339 で、format_time(t, layout) は Go の time パッケージの書式で時刻を文字列にする
+func builtinFormatTime(args ...object.Object) object.Object {
+	if len(args) != 1 && len(args) != 2 {
+		return newError(BUILTIN_NUMBER_OF_ARGUMENT_RANGE_ERROR, len(args), 1, 2)
+	}
+	t, ok := args[0].(*object.Integer)
+	if !ok {
+		return newError(BUILTIN_ARGUMENT_TYPE_MISMATCH, 1, "format_time", object.INTEGER_OBJECT, args[0].Type())
+	}
+	layout := defaultTimeLayout
+	if len(args) == 2 {
+		str, ok := args[1].(*object.String)
+		if !ok {
+			return newError(BUILTIN_ARGUMENT_TYPE_MISMATCH, 2, "format_time", object.STRING_OBJECT, args[1].Type())
+		}
+		layout = str.Value
+	}
+	return &object.String{Value: time.UnixMilli(t.Value).UTC().Format(layout)}
+}
+
+// parse_time(s) は RFC 3339 の、parse_time(s, layout) は Go の time パッケージの書式の文字列を時刻にする
+// タイムゾーンを含まない書式は UTC として解釈する
+func builtinParseTime(args ...object.Object) object.Object {
+	if len(args) != 1 && len(args) != 2 {
+		return newError(BUILTIN_NUMBER_OF_ARGUMENT_RANGE_ERROR, len(args), 1, 2)
+	}
+	values, err := stringArguments("parse_time", args, len(args))
+	if err != nil {
+		return err
+	}
+	layout := time.RFC3339
+	if len(values) == 2 {
+		layout = values[1]
+	}
+	t, parseErr := time.Parse(layout, values[0])
+	if parseErr != nil {
+		return newError(TIME_PARSE_ERROR, values[0], layout)
+	}
+	return &object.Integer{Value: t.UnixMilli()}
+}
+
+// "1h30m" のような Go の time.ParseDuration の書式の期間をミリ秒にする
+// ミリ秒未満は切り捨てる
+func builtinDuration(args ...object.Object) object.Object {
+	values, err := stringArguments("duration", args, 1)
+	if err != nil {
+		return err
+	}
+	d, parseErr := time.ParseDuration(values[0])
+	if parseErr != nil {
+		return newError(DURATION_PARSE_ERROR, values[0])
+	}
+	return &object.Integer{Value: d.Milliseconds()}
+}
+
+// ミリ秒の期間を "1h30m0s" のような文字列にする
+func builtinFormatDuration(args ...object.Object) object.Object {
+	values, err := integerArguments("format_duration", args, 1)
+	if err != nil {
+		return err
+	}
+	d, err := milliseconds(values[0])
+	if err != nil {
+		return err
+	}
+	return &object.String{Value: d.String()}
+}
@@ -0,0 +1,64 @@
+package evaluator
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// now と sleep で使う時計
+type Clock interface {
+	Now() time.Time
+	// d だけ待つ。ctx が終了した場合は待つのをやめて ctx.Err() を返す
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+// 実際の時刻を使う時計（デフォルト）
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// 実際には待たずに時刻を進める時計
+// テストで sleep を含むスクリプトをすぐに実行するために使う
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.Advance(d)
+	return nil
+}
+
+// 時刻を d だけ進める
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
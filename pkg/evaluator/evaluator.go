@@ -12,104 +12,134 @@ const (
 	TYPE_MISMATCH_ERROR_PREFIX        = "type mismatch: "
 	IDENTIFIER_NOT_FOUND_ERROR_PREFIX = "identifier not found: "
 	NOT_FUNCTION_ERROR                = "not a function: "
+	WRONG_NUMBER_OF_ARGUMENTS         = "wrong number of arguments: want=%d, got=%d"
 	INDEX_TYPE_MISMATCH               = "index operator not supported: "
 	UNUSABLE_HASH_KEY                 = "unusable as hash key: "
+	PROPERTY_NOT_SUPPORTED            = "property access not supported: "
+	UNDEFINED_METHOD                  = "undefined method: "
+	SLICE_TYPE_MISMATCH               = "slice operator not supported: "
+	INTEGER_DIVISION_BY_ZERO          = "division by zero: %d / 0"
+	SLICE_INDEX_TYPE_MISMATCH         = "slice index must be INTEGER, got "
 )
 
 var (
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
-	NULL  = &object.Null{}
+	TRUE  = object.TRUE
+	FALSE = object.FALSE
+	NULL  = object.NULL
 )
 
-func Eval(node ast.Node, env *object.Environment) object.Object {
+func (s *state) eval(node ast.Node, env *object.Environment) object.Object {
+	if err := s.step(); err != nil {
+		return err
+	}
+	if err := s.nest(); err != nil {
+		return err
+	}
+	result := s.evalNode(node, env)
+	s.nesting--
+	return result
+}
+
+func (s *state) evalNode(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 	case *ast.Program:
-		return evalProgram(node, env)
+		return s.evalProgram(node, env)
 	case *ast.BlockStatement:
-		return evalBlockStatements(node, env)
+		return s.evalBlockStatements(node, env)
 	case *ast.LetStatement:
-		val := Eval(node.Value, env)
+		val := s.eval(node.Value, env)
 		if isError(val) {
 			return val
 		}
-		env.Set(node.Name.Value, val)
+		if node.Name.Local {
+			env.SetAt(node.Name.Slot, val)
+		} else if err := env.Set(node.Name.Value, val); isError(err) {
+			return err
+		}
 	case *ast.ReturnStatement:
-		val := Eval(node.ReturnValue, env)
+		val := s.eval(node.ReturnValue, env)
 		if isError(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
 	case *ast.ExpressionStatement:
-		return Eval(node.Expression, env)
+		return s.eval(node.Expression, env)
 	case *ast.IntegerLiteral:
-		return &object.Integer{Value: node.Value}
+		return s.track(&object.Integer{Value: node.Value})
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 	case *ast.PrefixExpression:
-		right := Eval(node.Right, env)
+		right := s.eval(node.Right, env)
 		if isError(right) {
 			return right
 		}
-		return evalPrefixExpression(node.Operator, right)
+		return s.track(evalPrefixExpression(node.Operator, right))
 	case *ast.InfixExpression:
-		left := Eval(node.Left, env)
+		if node.Operator == "|>" {
+			return s.evalPipeExpression(node, env)
+		}
+		left := s.eval(node.Left, env)
 		if isError(left) {
 			return left
 		}
-		right := Eval(node.Right, env)
+		right := s.eval(node.Right, env)
 		if isError(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, left, right)
+		return s.track(evalInfixExpression(node.Operator, left, right))
 	case *ast.IfExpression:
-		return evalIfExpression(node, env)
+		return s.evalIfExpression(node, env)
 	case *ast.Identifer:
-		return evalIdentifier(node, env)
+		return s.evalIdentifier(node, env)
 	case *ast.FunctionLiteral:
 		params := node.Patameters
 		body := node.Body
-		return &object.Function{Parameters: params, Env: env, Body: body}
+		return s.track(&object.Function{Parameters: params, Env: env, Body: body, NumLocals: node.NumLocals})
 	case *ast.CallExpression:
-		function := Eval(node.Function, env)
-		if isError(function) {
-			return function
-		}
-		args := evalExpressions(node.Arguments, env)
-		if len(args) == 1 && isError(args[0]) {
-			return args[0]
-		}
-		return applyFunction(function, args)
+		return s.evalCallExpression(node, env)
 	case *ast.StringLiteral:
-		return &object.String{Value: node.Value}
+		return s.track(&object.String{Value: node.Value})
 	case *ast.ArrayLiteral:
-		elements := evalExpressions(node.Elements, env)
+		elements := s.evalExpressions(node.Elements, env)
 		if len(elements) == 1 && isError(elements[0]) {
 			return elements[0]
 		}
-		return &object.Array{Elements: elements}
+		return s.track(&object.Array{Elements: elements})
 	case *ast.IndexExpression:
-		left := Eval(node.Left, env)
+		left := s.eval(node.Left, env)
 		if isError(left) {
 			return left
 		}
-		index := Eval(node.Index, env)
+		index := s.eval(node.Index, env)
 		if isError(index) {
 			return index
 		}
-		return evalIndexExpression(left, index)
+		return s.track(evalIndexExpression(left, index))
+	case *ast.SliceExpression:
+		return s.evalSliceExpression(node, env)
+	case *ast.PropertyExpression:
+		left := s.eval(node.Left, env)
+		if isError(left) {
+			return left
+		}
+		return evalPropertyExpression(left, node.Property.Value)
 	case *ast.HashLiteral:
-		return evalHashLiteral(node, env)
+		return s.evalHashLiteral(node, env)
 	}
 
 	return nil
 }
 
-func evalProgram(program *ast.Program, env *object.Environment) object.Object {
+// 実行前に変数の位置を解決し、未定義の変数があればエラーを返す
+func (s *state) evalProgram(program *ast.Program, env *object.Environment) object.Object {
+	if err := resolve(program, env, s.interp); err != nil {
+		return err
+	}
+
 	var result object.Object
 
 	for _, stmt := range program.Statements {
-		result = Eval(stmt, env)
+		result = s.eval(stmt, env)
 
 		switch result := result.(type) {
 		case *object.ReturnValue:
@@ -122,11 +152,11 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 	return result
 }
 
-func evalBlockStatements(block *ast.BlockStatement, env *object.Environment) object.Object {
+func (s *state) evalBlockStatements(block *ast.BlockStatement, env *object.Environment) object.Object {
 	var result object.Object
 
 	for _, stmt := range block.Statements {
-		result = Eval(stmt, env)
+		result = s.eval(stmt, env)
 
 		if result != nil {
 			rt := result.Type()
@@ -139,11 +169,11 @@ func evalBlockStatements(block *ast.BlockStatement, env *object.Environment) obj
 	return result
 }
 
-func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
+func (s *state) evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
 	var result []object.Object
 
 	for _, e := range exps {
-		evaluated := Eval(e, env)
+		evaluated := s.eval(e, env)
 		if isError(evaluated) {
 			return []object.Object{evaluated}
 		}
@@ -153,28 +183,40 @@ func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Ob
 	return result
 }
 
-func evalIdentifier(ident *ast.Identifer, env *object.Environment) object.Object {
+func (s *state) evalIdentifier(ident *ast.Identifer, env *object.Environment) object.Object {
+	if ident.Local {
+		// if の中の let 文のように、代入されずに参照される場合がある
+		if val := env.GetAt(ident.Depth, ident.Slot); val != nil {
+			return val
+		}
+		return newError(IDENTIFIER_NOT_FOUND_ERROR_PREFIX + ident.Value)
+	}
+
 	if val, ok := env.Get(ident.Value); ok {
 		return val
 	}
 
-	if builtin, ok := builtins[ident.Value]; ok {
+	if builtin, ok := s.interp.builtins[ident.Value]; ok {
 		return builtin
 	}
 
+	if value, ok := s.interp.values[ident.Value]; ok {
+		return value
+	}
+
 	return newError(IDENTIFIER_NOT_FOUND_ERROR_PREFIX + ident.Value)
 }
 
-func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
-	condition := Eval(ie.Condition, env)
+func (s *state) evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
+	condition := s.eval(ie.Condition, env)
 	if isError(condition) {
 		return condition
 	}
 
 	if isTruthy(condition) {
-		return Eval(ie.Consequence, env)
+		return s.eval(ie.Consequence, env)
 	} else if ie.Alternative != nil {
-		return Eval(ie.Alternative, env)
+		return s.eval(ie.Alternative, env)
 	}
 
 	return NULL
@@ -220,6 +262,9 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 	case "*":
 		return &object.Integer{Value: leftVal * rightVal}
 	case "/":
+		if rightVal == 0 {
+			return newError(INTEGER_DIVISION_BY_ZERO, leftVal)
+		}
 		return &object.Integer{Value: leftVal / rightVal}
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
@@ -240,6 +285,9 @@ func evalStringInfixExpression(operator string, left, right object.Object) objec
 	}
 	leftVal := left.(*object.String).Value
 	rightVal := right.(*object.String).Value
+	if err := checkStringLength(int64(len(leftVal)) + int64(len(rightVal))); err != nil {
+		return err
+	}
 	return &object.String{Value: leftVal + rightVal}
 }
 
@@ -269,6 +317,8 @@ func evalIndexExpression(left, index object.Object) object.Object {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJECT:
 		return evalArrayIndexExpression(left, index)
+	case left.Type() == object.STRING_OBJECT && index.Type() == object.INTEGER_OBJECT:
+		return evalStringIndexExpression(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return evalhashIndexExpression(left, index)
 	default:
@@ -289,21 +339,233 @@ func evalhashIndexExpression(hash, index object.Object) object.Object {
 	return pair.Value
 }
 
+// 負のインデックスは末尾から数える
 func evalArrayIndexExpression(array, index object.Object) object.Object {
 	arrayObj := array.(*object.Array)
-	idx := index.(*object.Integer).Value
-	length := len(arrayObj.Elements) - 1
-
-	if idx < 0 || int64(length) < idx {
+	idx, ok := normalizeIndex(index.(*object.Integer).Value, len(arrayObj.Elements))
+	if !ok {
 		return NULL
 	}
 	return arrayObj.Elements[idx]
 }
 
-func evalHashLiteral(hash *ast.HashLiteral, env *object.Environment) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
-	for k, v := range hash.Pairs {
-		key := Eval(k, env)
+// 文字列のインデックスはバイト単位（len と同じ）
+func evalStringIndexExpression(str, index object.Object) object.Object {
+	value := str.(*object.String).Value
+	idx, ok := normalizeIndex(index.(*object.Integer).Value, len(value))
+	if !ok {
+		return NULL
+	}
+	return &object.String{Value: value[idx : idx+1]}
+}
+
+// 負のインデックスを末尾からの位置に変換する
+// 範囲外の場合は false を返す
+func normalizeIndex(idx int64, length int) (int64, bool) {
+	if idx < 0 {
+		idx += int64(length)
+	}
+	if idx < 0 || int64(length) <= idx {
+		return 0, false
+	}
+	return idx, true
+}
+
+func (s *state) evalSliceExpression(se *ast.SliceExpression, env *object.Environment) object.Object {
+	left := s.eval(se.Left, env)
+	if isError(left) {
+		return left
+	}
+
+	var low, high object.Object
+	if se.Low != nil {
+		low = s.eval(se.Low, env)
+		if isError(low) {
+			return low
+		}
+	}
+	if se.High != nil {
+		high = s.eval(se.High, env)
+		if isError(high) {
+			return high
+		}
+	}
+
+	return s.track(sliceObject(left, low, high))
+}
+
+// left[low:high] を返す
+// low, high が nil の場合は省略されたものとして扱う
+func sliceObject(left, low, high object.Object) object.Object {
+	var length int
+	switch left := left.(type) {
+	case *object.Array:
+		length = len(left.Elements)
+	case *object.String:
+		length = len(left.Value)
+	default:
+		return newError(SLICE_TYPE_MISMATCH+"%s", left.Type())
+	}
+
+	lowIdx, err := sliceBound(low, 0, length)
+	if err != nil {
+		return err
+	}
+	highIdx, err := sliceBound(high, length, length)
+	if err != nil {
+		return err
+	}
+	if highIdx < lowIdx {
+		highIdx = lowIdx
+	}
+
+	switch left := left.(type) {
+	case *object.Array:
+		elements := make([]object.Object, highIdx-lowIdx)
+		copy(elements, left.Elements[lowIdx:highIdx])
+		return &object.Array{Elements: elements}
+	default:
+		return &object.String{Value: left.(*object.String).Value[lowIdx:highIdx]}
+	}
+}
+
+// スライスの境界をインデックスに変換する
+// 省略された場合は defaultValue を、負の値は末尾から数え、範囲外は 0..length に丸める
+func sliceBound(bound object.Object, defaultValue, length int) (int, *object.Error) {
+	if bound == nil {
+		return defaultValue, nil
+	}
+	integer, ok := bound.(*object.Integer)
+	if !ok {
+		return 0, newError(SLICE_INDEX_TYPE_MISMATCH+"%s", bound.Type())
+	}
+
+	idx := integer.Value
+	if idx < 0 {
+		idx += int64(length)
+	}
+	if idx < 0 {
+		return 0, nil
+	}
+	if int64(length) < idx {
+		return length, nil
+	}
+	return int(idx), nil
+}
+
+func evalPropertyExpression(left object.Object, name string) object.Object {
+	hash, ok := left.(*object.Hash)
+	if !ok {
+		return newError(PROPERTY_NOT_SUPPORTED+"%s", left.Type())
+	}
+	return evalhashIndexExpression(hash, &object.String{Value: name})
+}
+
+// 引数を評価する
+// function がエラーを受け取る組み込み関数の場合は、実行時エラーも引数の値として評価する
+// それ以外の場合と実行制限によるエラーは、評価を止めてエラーを返す
+func (s *state) evalArguments(function object.Object, exps []ast.Expression, env *object.Environment) ([]object.Object, *object.Error) {
+	args := make([]object.Object, 0, len(exps))
+	for _, e := range exps {
+		evaluated := s.eval(e, env)
+		if err, ok := evaluated.(*object.Error); ok && !(acceptsErrors(function) && err.Kind == object.RUNTIME_ERROR) {
+			return nil, err
+		}
+		args = append(args, evaluated)
+	}
+	return args, nil
+}
+
+// エラーを引数として受け取る組み込み関数か
+func acceptsErrors(function object.Object) bool {
+	builtin, ok := function.(*object.Builtin)
+	return ok && builtin.AcceptsErrors
+}
+
+func (s *state) evalCallExpression(call *ast.CallExpression, env *object.Environment) object.Object {
+	return s.evalCall(call, call.Arguments, env)
+}
+
+// call の関数を args で呼び出す
+// 関数を先に評価してから、引数を順に評価する
+func (s *state) evalCall(call *ast.CallExpression, args []ast.Expression, env *object.Environment) object.Object {
+	if property, ok := call.Function.(*ast.PropertyExpression); ok {
+		return s.evalMethodCall(call, property, args, env)
+	}
+	function := s.eval(call.Function, env)
+	if isError(function) {
+		return function
+	}
+	evaluated, err := s.evalArguments(function, args, env)
+	if err != nil {
+		return err
+	}
+	return s.applyCall(call, function, evaluated)
+}
+
+// left |> right を評価する
+// right が関数呼び出しなら left をその第１引数に、それ以外は right を関数として left を引数に呼び出す
+// 通常の呼び出しと同じく関数を先に評価するので、left がエラーでも is_error などに渡せる
+func (s *state) evalPipeExpression(pipe *ast.InfixExpression, env *object.Environment) object.Object {
+	if call, ok := pipe.Right.(*ast.CallExpression); ok {
+		return s.evalCall(call, append([]ast.Expression{pipe.Left}, call.Arguments...), env)
+	}
+	function := s.eval(pipe.Right, env)
+	if isError(function) {
+		return function
+	}
+	args, err := s.evalArguments(function, []ast.Expression{pipe.Left}, env)
+	if err != nil {
+		return err
+	}
+	return s.applyFunction(function, args)
+}
+
+// receiver.method(args) を評価する
+// receiver がハッシュで method をキーに持つ場合はその値を関数として呼び出し、
+// それ以外は method という名前の組み込み関数を receiver を第１引数にして呼び出す
+// receiver の実行時エラーは、method がエラーを受け取る組み込み関数の場合だけ receiver として渡す
+func (s *state) evalMethodCall(call *ast.CallExpression, property *ast.PropertyExpression, args []ast.Expression, env *object.Environment) object.Object {
+	receiver := s.eval(property.Left, env)
+	if err, ok := receiver.(*object.Error); ok && err.Kind != object.RUNTIME_ERROR {
+		return receiver
+	}
+
+	function, leading := s.interp.lookupMethod(receiver, property.Property.Value)
+	if isError(receiver) && !acceptsErrors(function) {
+		return receiver
+	}
+	if isError(function) {
+		return function
+	}
+
+	evaluated, err := s.evalArguments(function, args, env)
+	if err != nil {
+		return err
+	}
+	return s.applyCall(call, function, append(leading, evaluated...))
+}
+
+// receiver.name で呼び出す関数と、引数リストの先頭に置く値を返す
+// receiver がハッシュで name をキーに持つ場合はその値を、
+// それ以外は name という名前の組み込み関数と receiver を返す
+func (in *Interpreter) lookupMethod(receiver object.Object, name string) (object.Object, []object.Object) {
+	if hash, ok := receiver.(*object.Hash); ok {
+		if pair, ok := hash.Pairs[(&object.String{Value: name}).HashKey()]; ok {
+			return pair.Value, nil
+		}
+	}
+	builtin, ok := in.builtins[name]
+	if !ok {
+		return newError(UNDEFINED_METHOD+"%s.%s", receiver.Type(), name), nil
+	}
+	return builtin, []object.Object{receiver}
+}
+
+func (s *state) evalHashLiteral(hash *ast.HashLiteral, env *object.Environment) object.Object {
+	result := object.NewHash(len(hash.Pairs))
+	for _, k := range hash.OrderedKeys() {
+		key := s.eval(k, env)
 		if isError(key) {
 			return key
 		}
@@ -311,37 +573,92 @@ func evalHashLiteral(hash *ast.HashLiteral, env *object.Environment) object.Obje
 		if !ok {
 			return newError(UNUSABLE_HASH_KEY+"%s", key.Type())
 		}
-		value := Eval(v, env)
+		value := s.eval(hash.Pairs[k], env)
 		if isError(value) {
 			return value
 		}
-		pairs[hashKey.HashKey()] = object.HashPair{Key: key, Value: value}
+		result.Set(hashKey.HashKey(), object.HashPair{Key: key, Value: value})
 	}
 
-	return &object.Hash{Pairs: pairs}
+	return s.track(result)
 }
 
-func applyFunction(function object.Object, args []object.Object) object.Object {
+func (s *state) applyFunction(function object.Object, args []object.Object) object.Object {
 	switch fn := function.(type) {
 	case *object.Builtin:
-		return fn.Fn(args...)
+		if fn.HigherOrderFn != nil {
+			return s.track(fn.HigherOrderFn(s.callFunction, args...))
+		}
+		return s.track(fn.Fn(args...))
 	case *object.Function:
-		evaluatedEnv := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, evaluatedEnv)
-		return unwrapReturnValue(evaluated)
+		if err := s.enter(); err != nil {
+			return err
+		}
+		defer s.leave()
+
+		// 末尾呼び出しは Go のスタックを積まずにこのループで続けて評価する
+		for {
+			if len(args) < len(fn.Parameters) {
+				return newError(WRONG_NUMBER_OF_ARGUMENTS, len(fn.Parameters), len(args))
+			}
+			// 呼び出しごとの環境も数える
+			if err := s.allocate(1); err != nil {
+				return err
+			}
+			evaluatedEnv := extendFunctionEnv(fn, args)
+			evaluated := unwrapReturnValue(s.eval(fn.Body, evaluatedEnv))
+
+			tc, ok := evaluated.(*tailCall)
+			if !ok {
+				return evaluated
+			}
+			fn, args = tc.function, tc.args
+		}
 	default:
 		return newError(NOT_FUNCTION_ERROR+"%s", function.Type())
 	}
 }
 
+// 末尾位置の関数呼び出しは評価せずに tailCall を返し、呼び出し元の applyFunction に任せる
+// 組み込み関数からスクリプトの関数を呼び出す
+func (s *state) callFunction(fn object.Object, args ...object.Object) object.Object {
+	result := s.applyFunction(fn, args)
+	if result == nil {
+		return NULL
+	}
+	return result
+}
+
+func (s *state) applyCall(call *ast.CallExpression, function object.Object, args []object.Object) object.Object {
+	if fn, ok := function.(*object.Function); ok && call.Tail {
+		return &tailCall{function: fn, args: args}
+	}
+	return s.applyFunction(function, args)
+}
+
 func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
-	env := object.NewEnclosedEnvironment(fn.Env)
+	env := object.NewEnclosedEnvironment(fn.Env, fn.NumLocals)
 	for i, param := range fn.Parameters {
-		env.Set(param.Value, args[i])
+		env.SetAt(param.Slot, args[i])
 	}
 	return env
 }
 
+// 末尾位置の関数呼び出し
+// applyFunction のループで評価されるので、言語の値としては現れない
+type tailCall struct {
+	function *object.Function
+	args     []object.Object
+}
+
+func (tc *tailCall) Type() object.ObjectType {
+	return "TAIL_CALL"
+}
+
+func (tc *tailCall) Inspect() string {
+	return "tail call"
+}
+
 func unwrapReturnValue(obj object.Object) object.Object {
 	if rv, ok := obj.(*object.ReturnValue); ok {
 		return rv.Value
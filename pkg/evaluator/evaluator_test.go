@@ -1,9 +1,19 @@
 package evaluator
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
 
+	"github.com/oteto/gonkey/pkg/ast"
 	"github.com/oteto/gonkey/pkg/object"
 	"github.com/oteto/gonkey/pkg/parser"
 	"github.com/oteto/gonkey/pkg/tokenizer"
@@ -166,7 +176,7 @@ if (10 > 1) {
 	if (10 > 1) {
 		return true + false;
 	}
-	reutrn 1;
+	return 1;
 }
 			`,
 			UNKOWN_OPERATOR_ERROR_PREFIX + "BOOLEAN + BOOLEAN",
@@ -183,6 +193,10 @@ if (10 > 1) {
 			`{"name": "monkey"}[fn(){}]`,
 			UNUSABLE_HASH_KEY + "FUNCTION",
 		},
+		{
+			"let x = 0; 10 / x",
+			fmt.Sprintf(INTEGER_DIVISION_BY_ZERO, 10),
+		},
 	}
 
 	for _, tt := range tests {
@@ -256,6 +270,485 @@ func TestFunctionApplication(t *testing.T) {
 	}
 }
 
+func TestFunctionApplicationWithWrongArguments(t *testing.T) {
+	evaluated := testEval("let add = fn(x, y) { x + y; }; add(1);")
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+	}
+	expect := fmt.Sprintf(WRONG_NUMBER_OF_ARGUMENTS, 2, 1)
+	if errObj.Message != expect {
+		t.Fatalf("wrong error message. want=%q, got=%q", expect, errObj.Message)
+	}
+}
+
+func TestScopeResolution(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect interface{}
+	}{
+		{"let x = 1; let f = fn() { let y = x + 1; let x = 10; x + y }; f()", 12},
+		{"let f = fn(x) { let x = x * 2; x }; f(3)", 6},
+		{"let f = fn(a) { fn(b) { fn(c) { a + b + c } } }; f(1)(2)(3)", 6},
+		{"let f = fn() { let g = fn() { x }; let x = 5; g() }; f()", 5},
+		{"let f = fn() { let count = fn(n) { if (n == 0) { 0 } else { 1 + count(n - 1) } }; count(5) }; f()", 5},
+		{"let f = fn() { g() }; let g = fn() { 3 }; f()", 3},
+		{"let f = fn(n) { if (n > 0) { let y = n; } y }; f(1)", 1},
+		{"let f = fn(n) { if (n > 0) { let y = n; } y }; f(0)", IDENTIFIER_NOT_FOUND_ERROR_PREFIX + "y"},
+		{"let f = fn() { let g = fn() { x }; g() }; f()", IDENTIFIER_NOT_FOUND_ERROR_PREFIX + "x"},
+		{"x; let x = 1;", IDENTIFIER_NOT_FOUND_ERROR_PREFIX + "x"},
+		{"let len = fn(x) { 0 }; len([1])", 0},
+		{`{"a": 1}.a`, 1},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expect := tt.expect.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expect))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Fatalf("%s: object is not Error. got=%T (%+v)", tt.input, evaluated, evaluated)
+			}
+			if errObj.Message != expect {
+				t.Fatalf("wrong error message. want=%q, got=%q", expect, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestTailCall(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect int64
+	}{
+		{"let count = fn(n, acc) { if (n == 0) { acc } else { count(n - 1, acc + 1) } }; count(100000, 0)", 100000},
+		{"let count = fn(n, acc) { if (n == 0) { return acc; } return count(n - 1, acc + 2); }; count(100000, 0)", 200000},
+		{"let even = fn(n) { if (n == 0) { 1 } else { odd(n - 1) } }; let odd = fn(n) { if (n == 0) { 0 } else { even(n - 1) } }; even(100001)", 0},
+		{"let count = fn(n) { if (n == 0) { 0 } else { n - 1 |> count() } }; count(100000)", 0},
+		{"let h = {\"count\": fn(n) { if (n == 0) { 7 } else { h.count(n - 1) } }}; h.count(100000)", 7},
+		{"let sum = fn(n) { if (n == 0) { 0 } else { n + sum(n - 1) } }; sum(100)", 5050},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testIntegerObject(t, evaluated, tt.expect)
+	}
+}
+
+func TestMarkTailCalls(t *testing.T) {
+	input := "fn(n) { let a = f(n); if (a) { return g(a); } if (n) { h(n) } else { 1 + i(n) } }"
+	p := parser.New(tokenizer.New(input))
+	program := p.ParseProgram()
+	Eval(program, object.NewEnvironment())
+
+	tails := map[string]bool{}
+	ast.Inspect(program, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpression); ok {
+			tails[call.Function.String()] = call.Tail
+		}
+		return true
+	})
+
+	expect := map[string]bool{"f": false, "g": true, "h": true, "i": false}
+	for name, tail := range expect {
+		if tails[name] != tail {
+			t.Errorf("%s: Tail wrong. want=%t, got=%t", name, tail, tails[name])
+		}
+	}
+}
+
+func TestEvalContextLimits(t *testing.T) {
+	loop := "let loop = fn(n) { loop(n + 1) }; loop(0)"
+	recursion := "let f = fn(n) { 1 + f(n + 1) }; f(0)"
+	allocation := "let f = fn(acc) { f(push(acc, 1)) }; f([])"
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		ctx    context.Context
+		input  string
+		opts   []Option
+		kind   object.ErrorKind
+		expect string
+	}{
+		{context.Background(), loop, []Option{WithMaxSteps(1000)}, object.STEP_LIMIT_ERROR, fmt.Sprintf(STEP_LIMIT_EXCEEDED, 1000)},
+		{context.Background(), recursion, []Option{WithMaxSteps(10000)}, object.STEP_LIMIT_ERROR, fmt.Sprintf(STEP_LIMIT_EXCEEDED, 10000)},
+		{context.Background(), allocation, []Option{WithMaxAllocations(500)}, object.ALLOCATION_LIMIT_ERROR, fmt.Sprintf(ALLOCATION_LIMIT_EXCEEDED, 500)},
+		{context.Background(), loop, []Option{WithTimeout(10 * time.Millisecond)}, object.TIMEOUT_ERROR, EXECUTION_TIMEOUT},
+		{canceled, loop, nil, object.CANCELED_ERROR, EXECUTION_CANCELED},
+		{context.Background(), "map(range(100000), fn(x) { x })", []Option{WithMaxSteps(1000)}, object.STEP_LIMIT_ERROR, fmt.Sprintf(STEP_LIMIT_EXCEEDED, 1000)},
+		{context.Background(), "len(range(3000000))", []Option{WithMaxAllocations(1000)}, object.ALLOCATION_LIMIT_ERROR, fmt.Sprintf(ALLOCATION_LIMIT_EXCEEDED, 1000)},
+		{context.Background(), "range(100000000)", []Option{WithMaxAllocations(1000), WithTimeout(50 * time.Millisecond)}, object.ALLOCATION_LIMIT_ERROR, fmt.Sprintf(ALLOCATION_LIMIT_EXCEEDED, 1000)},
+		{context.Background(), "range(-9223372036854775807 - 1, 9223372036854775807)", []Option{WithMaxAllocations(1000)}, object.ALLOCATION_LIMIT_ERROR, fmt.Sprintf(ALLOCATION_LIMIT_EXCEEDED, 1000)},
+		{context.Background(), "map(range(500), fn(x) { 0 })", []Option{WithMaxAllocations(900)}, object.ALLOCATION_LIMIT_ERROR, fmt.Sprintf(ALLOCATION_LIMIT_EXCEEDED, 900)},
+		{context.Background(), "let xs = range(400); zip(xs, xs)", []Option{WithMaxAllocations(1000)}, object.ALLOCATION_LIMIT_ERROR, fmt.Sprintf(ALLOCATION_LIMIT_EXCEEDED, 1000)},
+		{context.Background(), "sort(range(600))", []Option{WithMaxAllocations(1000)}, object.ALLOCATION_LIMIT_ERROR, fmt.Sprintf(ALLOCATION_LIMIT_EXCEEDED, 1000)},
+		{context.Background(), `chars(repeat("a", 2000))`, []Option{WithMaxAllocations(1000)}, object.ALLOCATION_LIMIT_ERROR, fmt.Sprintf(ALLOCATION_LIMIT_EXCEEDED, 1000)},
+		{context.Background(), `split(repeat("a,", 2000), ",")`, []Option{WithMaxAllocations(1000)}, object.ALLOCATION_LIMIT_ERROR, fmt.Sprintf(ALLOCATION_LIMIT_EXCEEDED, 1000)},
+		{context.Background(), `find_all("a", repeat("a", 2000))`, []Option{WithMaxAllocations(1000)}, object.ALLOCATION_LIMIT_ERROR, fmt.Sprintf(ALLOCATION_LIMIT_EXCEEDED, 1000)},
+		{context.Background(), `json_parse("[" + repeat("1,", 1999) + "1]")`, []Option{WithMaxAllocations(1000)}, object.ALLOCATION_LIMIT_ERROR, fmt.Sprintf(ALLOCATION_LIMIT_EXCEEDED, 1000)},
+		{context.Background(), `let key = json_stringify("a"); json_parse("{" + repeat(key + ": 1, ", 1999) + key + ": 1}")`, []Option{WithMaxAllocations(1000)}, object.ALLOCATION_LIMIT_ERROR, fmt.Sprintf(ALLOCATION_LIMIT_EXCEEDED, 1000)},
+		{canceled, "range(10)", nil, object.CANCELED_ERROR, EXECUTION_CANCELED},
+		{context.Background(), recursion, nil, object.DEPTH_LIMIT_ERROR, fmt.Sprintf(DEPTH_LIMIT_EXCEEDED, DefaultMaxDepth)},
+		{context.Background(), recursion, []Option{WithMaxDepth(100)}, object.DEPTH_LIMIT_ERROR, fmt.Sprintf(DEPTH_LIMIT_EXCEEDED, 100)},
+		{context.Background(), "let f = fn(n) { map([n], f) }; f(0)", []Option{WithMaxDepth(100)}, object.DEPTH_LIMIT_ERROR, fmt.Sprintf(DEPTH_LIMIT_EXCEEDED, 100)},
+		{context.Background(), recursion, []Option{WithMaxDepth(100000000)}, object.DEPTH_LIMIT_ERROR, fmt.Sprintf(NESTING_LIMIT_EXCEEDED, maxNesting)},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(tokenizer.New(tt.input))
+		evaluated := EvalContext(tt.ctx, p.ParseProgram(), object.NewEnvironment(), tt.opts...)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Fatalf("%s: object is not Error. got=%T (%+v)", tt.input, evaluated, evaluated)
+		}
+		if errObj.Kind != tt.kind {
+			t.Errorf("%s: wrong error kind. want=%q, got=%q", tt.input, tt.kind, errObj.Kind)
+		}
+		if errObj.Message != tt.expect {
+			t.Errorf("%s: wrong error message. want=%q, got=%q", tt.input, tt.expect, errObj.Message)
+		}
+	}
+}
+
+func TestRangeLength(t *testing.T) {
+	tests := []struct {
+		start, end, step int64
+		expect           int
+	}{
+		{0, 10, 1, 10},
+		{0, 10, 3, 4},
+		{10, 0, -3, 4},
+		{0, 0, 1, 0},
+		{5, 0, 1, 0},
+		{0, 5, -1, 0},
+		{math.MinInt64, math.MaxInt64, math.MaxInt64, 3},
+		{math.MaxInt64, math.MinInt64, math.MinInt64, 2},
+	}
+
+	for _, tt := range tests {
+		if got := rangeLength(tt.start, tt.end, tt.step); got != tt.expect {
+			t.Errorf("rangeLength(%d, %d, %d): want=%d, got=%d", tt.start, tt.end, tt.step, tt.expect, got)
+		}
+	}
+}
+
+func TestDepthLimitAllowsDeepAndTailRecursion(t *testing.T) {
+	input := `
+let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } };
+let loop = fn(n) { if (n == 0) { 0 } else { loop(n - 1) } };
+[f(9999), loop(1000000)]`
+	p := parser.New(tokenizer.New(input))
+	evaluated := New().Eval(p.ParseProgram())
+	if evaluated.Inspect() != "[9999, 0]" {
+		t.Errorf("wrong result. got=%q", evaluated.Inspect())
+	}
+}
+
+func TestEvalContextWithinLimits(t *testing.T) {
+	input := "let f = fn(n) { if (n == 0) { 0 } else { n + f(n - 1) } }; f(10)"
+	p := parser.New(tokenizer.New(input))
+	evaluated := EvalContext(context.Background(), p.ParseProgram(), object.NewEnvironment(),
+		WithMaxSteps(10000), WithMaxAllocations(10000), WithTimeout(time.Second))
+	testIntegerObject(t, evaluated, 55)
+
+	runtimeErr := testEval("-true").(*object.Error)
+	if runtimeErr.Kind != object.RUNTIME_ERROR {
+		t.Errorf("wrong error kind. got=%q", runtimeErr.Kind)
+	}
+}
+
+func TestUndefinedIdentifierBeforeRun(t *testing.T) {
+	var buf bytes.Buffer
+	p := parser.New(tokenizer.New(`puts("run"); let f = fn() { undefined }; 1`))
+	evaluated := New(WithStdout(&buf)).Eval(p.ParseProgram())
+
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+	}
+	if errObj.Message != IDENTIFIER_NOT_FOUND_ERROR_PREFIX+"undefined" {
+		t.Fatalf("wrong error message. got=%q", errObj.Message)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("program was run. output=%q", buf.String())
+	}
+}
+
+func TestEputs(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	p := parser.New(tokenizer.New(`puts("out"); eputs("err", 1)`))
+	evaluated := New(WithStdout(&stdout), WithStderr(&stderr)).Eval(p.ParseProgram())
+	testNullObject(t, evaluated)
+
+	if stdout.String() != "out\n" {
+		t.Errorf("wrong stdout. got=%q", stdout.String())
+	}
+	if stderr.String() != "err\n1\n" {
+		t.Errorf("wrong stderr. got=%q", stderr.String())
+	}
+}
+
+func TestResolvedProgram(t *testing.T) {
+	p := parser.New(tokenizer.New(`puts("run"); undefined`))
+	program := p.ParseProgram()
+	Resolve(program)
+	if !program.Resolved {
+		t.Fatalf("program is not marked as resolved")
+	}
+
+	// 解決済みでも、未定義の変数は実行前にエラーにする
+	var buf bytes.Buffer
+	evaluated := New(WithStdout(&buf)).Eval(program)
+	errObj, ok := evaluated.(*object.Error)
+	if !ok || errObj.Message != IDENTIFIER_NOT_FOUND_ERROR_PREFIX+"undefined" {
+		t.Fatalf("wrong result. got=%+v", evaluated)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("program was run. output=%q", buf.String())
+	}
+
+	p = parser.New(tokenizer.New("let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; f(100000)"))
+	program = p.ParseProgram()
+	Resolve(program)
+	for i := 0; i < 2; i++ {
+		testIntegerObject(t, New().Eval(program), 0)
+	}
+}
+
+func TestGlobalsAcrossPrograms(t *testing.T) {
+	interp := New()
+	inputs := []string{
+		"let a = 2;",
+		"let double = fn(x) { x * a };",
+		"double(3)",
+	}
+
+	var evaluated object.Object
+	for _, input := range inputs {
+		p := parser.New(tokenizer.New(input))
+		evaluated = interp.Eval(p.ParseProgram())
+	}
+	testIntegerObject(t, evaluated, 6)
+
+	if _, ok := New().Env().Get("a"); ok {
+		t.Errorf("globals are shared between interpreters")
+	}
+}
+
+func TestInterpretersAreIsolated(t *testing.T) {
+	const n = 8
+
+	var wg sync.WaitGroup
+	outputs := make([]bytes.Buffer, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			input := fmt.Sprintf("let id = %d; let f = fn(n) { if (n > 0) { puts(id); f(n - 1) } }; f(100)", i)
+			p := parser.New(tokenizer.New(input))
+			New(WithStdout(&outputs[i])).Eval(p.ParseProgram())
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < n; i++ {
+		expect := strings.Repeat(fmt.Sprintf("%d\n", i), 100)
+		if outputs[i].String() != expect {
+			t.Errorf("output of interpreter %d is mixed. got=%q", i, outputs[i].String())
+		}
+	}
+}
+
+func TestReadOnlyBindings(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect interface{}
+	}{
+		{"env * 2", 84},
+		{"let f = fn() { env }; f()", 42},
+		{"let env = 1;", object.READ_ONLY_ERROR_PREFIX + "env"},
+		{"let f = fn() { let env = 1; env }; f()", object.READ_ONLY_ERROR_PREFIX + "env"},
+		{"let f = fn(env) { env }; f(1)", object.READ_ONLY_ERROR_PREFIX + "env"},
+		{"let other = 1; other", 1},
+	}
+
+	for _, tt := range tests {
+		interp := New()
+		interp.Env().SetReadOnly("env", &object.Integer{Value: 42})
+
+		p := parser.New(tokenizer.New(tt.input))
+		evaluated := interp.Eval(p.ParseProgram())
+
+		switch expect := tt.expect.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expect))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Fatalf("%s: object is not Error. got=%T (%+v)", tt.input, evaluated, evaluated)
+			}
+			if errObj.Message != expect {
+				t.Errorf("%s: wrong error message. want=%q, got=%q", tt.input, expect, errObj.Message)
+			}
+		}
+	}
+
+	env := object.NewEnvironment()
+	env.SetReadOnly("env", TRUE)
+	if result := env.Set("env", FALSE); !isError(result) {
+		t.Errorf("Set on read-only variable returned %+v", result)
+	}
+	if value, _ := env.Get("env"); value != TRUE {
+		t.Errorf("read-only variable changed. got=%+v", value)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	interp := New()
+	interp.Register("answer", func(args ...object.Object) object.Object {
+		return &object.Integer{Value: 42}
+	})
+	interp.Register("len", func(args ...object.Object) object.Object {
+		return &object.String{Value: "overridden"}
+	})
+
+	p := parser.New(tokenizer.New("answer()"))
+	testIntegerObject(t, interp.Eval(p.ParseProgram()), 42)
+
+	p = parser.New(tokenizer.New("[1].len()"))
+	testStringObject(t, interp.Eval(p.ParseProgram()), "overridden")
+
+	p = parser.New(tokenizer.New("answer()"))
+	if _, ok := New().Eval(p.ParseProgram()).(*object.Error); !ok {
+		t.Errorf("builtin is shared between interpreters")
+	}
+}
+
+func TestRegisterFunc(t *testing.T) {
+	interp := New()
+	funcs := map[string]interface{}{
+		"repeat":   strings.Repeat,
+		"small":    func(n int8) int8 { return n },
+		"unsigned": func(n uint) uint { return n },
+		"not":      func(b bool) bool { return !b },
+		"sum": func(nums ...int) int {
+			total := 0
+			for _, n := range nums {
+				total += n
+			}
+			return total
+		},
+		"check": func(s string) (string, error) {
+			if s == "" {
+				return "", fmt.Errorf("empty string")
+			}
+			return s, nil
+		},
+		"typeOf": func(o object.Object) string { return string(o.Type()) },
+		"noop":   func() {},
+		"total": func(nums []int) int {
+			total := 0
+			for _, n := range nums {
+				total += n
+			}
+			return total
+		},
+		"greet": func(p struct {
+			Name string `gonkey:"name"`
+		}) string {
+			return "hello " + p.Name
+		},
+		"lookup": func(m map[string]int, key string) int { return m[key] },
+		"first":  func(nums []int) int { return nums[0] },
+		"cyclic": func() map[string]interface{} {
+			m := map[string]interface{}{}
+			m["self"] = m
+			return m
+		},
+	}
+	for name, fn := range funcs {
+		if err := interp.RegisterFunc(name, fn); err != nil {
+			t.Fatalf("RegisterFunc(%s) error: %s", name, err)
+		}
+	}
+
+	tests := []struct {
+		input  string
+		expect interface{}
+	}{
+		{`repeat("ab", 3)`, "ababab"},
+		{`"ab".repeat(2)`, "abab"},
+		{"small(-5)", -5},
+		{"not(true)", false},
+		{"sum()", 0},
+		{"sum(1, 2, 3)", 6},
+		{`check("ok")`, "ok"},
+		{`typeOf([1])`, "ARRAY"},
+		{"noop()", nil},
+		{`check("")`, fmt.Errorf("empty string")},
+		{`repeat("ab")`, fmt.Errorf(BUILTIN_NUMBER_OF_ARGUMENT_ERROR, 1, 2)},
+		{`repeat(1, 2)`, fmt.Errorf(BUILTIN_ARGUMENT_TYPE_MISMATCH, 1, "repeat", object.STRING_OBJECT, object.INTEGER_OBJECT)},
+		{`sum(1, "2")`, fmt.Errorf(BUILTIN_ARGUMENT_TYPE_MISMATCH, 2, "sum", object.INTEGER_OBJECT, object.STRING_OBJECT)},
+		{"small(1000)", fmt.Errorf(BUILTIN_ARGUMENT_CONVERSION_ERROR, 1, "small", "1000 out of range for int8")},
+		{"unsigned(-1)", fmt.Errorf(BUILTIN_ARGUMENT_CONVERSION_ERROR, 1, "unsigned", "-1 out of range for uint")},
+		{"total([1, 2, 3])", 6},
+		{`total([1, "2"])`, fmt.Errorf(BUILTIN_ARGUMENT_CONVERSION_ERROR, 1, "total", "[1]: cannot convert STRING to int")},
+		{"total(1)", fmt.Errorf(BUILTIN_ARGUMENT_TYPE_MISMATCH, 1, "total", object.ARRAY_OBJ, object.INTEGER_OBJECT)},
+		{`greet({"name": "gonkey"})`, "hello gonkey"},
+		{`lookup({"a": 1, "b": 2}, "b")`, 2},
+		{"first([3])", 3},
+		{"first([])", fmt.Errorf(BUILTIN_PANIC, "first", "runtime error: index out of range [0] with length 0")},
+		{"cyclic()", fmt.Errorf("self: "+object.CONVERSION_CYCLE, "map[string]interface {}")},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(tokenizer.New(tt.input))
+		evaluated := interp.Eval(p.ParseProgram())
+
+		switch expect := tt.expect.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expect))
+		case string:
+			testStringObject(t, evaluated, expect)
+		case bool:
+			testBooleanObject(t, evaluated, expect)
+		case nil:
+			testNullObject(t, evaluated)
+		case error:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Fatalf("%s: object is not Error. got=%T (%+v)", tt.input, evaluated, evaluated)
+			}
+			if errObj.Message != expect.Error() {
+				t.Errorf("%s: wrong error message. want=%q, got=%q", tt.input, expect.Error(), errObj.Message)
+			}
+		}
+	}
+}
+
+func TestWrapFuncUnsupported(t *testing.T) {
+	tests := []interface{}{
+		1,
+		func(f float64) {},
+		func() (int, int) { return 0, 0 },
+		func() (int, string, error) { return 0, "", nil },
+		func() chan int { return nil },
+		nil,
+		(func())(nil),
+	}
+
+	for _, fn := range tests {
+		if _, err := WrapFunc("f", fn); err == nil {
+			t.Errorf("WrapFunc(%T) returned no error", fn)
+		}
+	}
+}
+
 func TestStringLiteral(t *testing.T) {
 	input := `"Hello World"`
 	evaluated := testEval(input)
@@ -290,6 +783,8 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`rest(rest([1,2,3]))`, []int{3}},
 		{`push([], 1)`, []int{1}},
 		{`push([1,2], 3)`, []int{1, 2, 3}},
+		{`len({})`, 0},
+		{`len({"a": 1, "b": 2})`, 2},
 	}
 
 	for _, tt := range tests {
@@ -322,6 +817,604 @@ func TestBuiltinFunctions(t *testing.T) {
 	}
 }
 
+func TestHashBuiltins(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect string
+	}{
+		{`keys({"b": 1, "a": 2, 3: 3})`, "[b, a, 3]"},
+		{`keys({})`, "[]"},
+		{`values({"b": 1, "a": 2})`, "[1, 2]"},
+		{`entries({"a": 1, true: [2]})`, "[[a, 1], [true, [2]]]"},
+		{`has({"a": 1}, "a")`, "true"},
+		{`has({"a": 1}, "b")`, "false"},
+		{`{"a": 1}.has("a")`, "true"},
+		{`delete({"a": 1, "b": 2, "c": 3}, "b")`, "{a: 1, c: 3}"},
+		{`delete({"a": 1}, "b")`, "{a: 1}"},
+		{`let h = {"a": 1}; delete(h, "a"); h`, "{a: 1}"},
+		{`merge({"a": 1, "b": 2}, {"b": 3, "c": 4})`, "{a: 1, b: 3, c: 4}"},
+		{`let h = {"a": 1}; merge(h, {"a": 2}); h`, "{a: 1}"},
+		{`keys([1])`, "ERROR: " + fmt.Sprintf(BUILTIN_ARGUMENT_TYPE_ERRROR, "keys", object.ARRAY_OBJ)},
+		{`has({}, [1])`, "ERROR: " + UNUSABLE_HASH_KEY + object.ARRAY_OBJ},
+		{`merge({}, 1)`, "ERROR: second " + fmt.Sprintf(BUILTIN_ARGUMENT_TYPE_ERRROR, "merge", object.INTEGER_OBJECT)},
+		{`delete({})`, "ERROR: " + fmt.Sprintf(BUILTIN_NUMBER_OF_ARGUMENT_ERROR, 1, 2)},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expect {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.expect, evaluated.Inspect())
+		}
+	}
+}
+
+func TestHigherOrderBuiltins(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect string
+	}{
+		{"map([1, 2, 3], fn(x) { x * 2 })", "[2, 4, 6]"},
+		{"let n = 10; [1, 2].map(fn(x) { x + n })", "[11, 12]"},
+		{`map(["a", "bc"], len)`, "[1, 2]"},
+		{"map([[1], [2, 3]], fn(a) { map(a, fn(x) { -x }) })", "[[-1], [-2, -3]]"},
+		{"filter([1, 2, 3, 4], fn(x) { x > 2 })", "[3, 4]"},
+		{"reduce([1, 2, 3], fn(acc, x) { acc + x }, 0)", "6"},
+		{"reduce([], fn(acc, x) { acc + x }, 10)", "10"},
+		{"sort([3, 1, 2])", "[1, 2, 3]"},
+		{`sort(["b", "c", "a"])`, "[a, b, c]"},
+		{"sort([1, 3, 2], fn(a, b) { a > b })", "[3, 2, 1]"},
+		{"let a = [2, 1]; sort(a); a", "[2, 1]"},
+		{"find([1, 2, 3], fn(x) { x > 1 })", "2"},
+		{"find([1, 2, 3], fn(x) { x > 5 })", "null"},
+		{"any([1, 2], fn(x) { x == 2 })", "true"},
+		{"any([], fn(x) { true })", "false"},
+		{"all([1, 2], fn(x) { x > 0 })", "true"},
+		{"all([1, 2], fn(x) { x > 1 })", "false"},
+		{`zip([1, 2, 3], ["a", "b"])`, "[[1, a], [2, b]]"},
+		{"range(3)", "[0, 1, 2]"},
+		{"range(2, 5)", "[2, 3, 4]"},
+		{"range(5, 0, -2)", "[5, 3, 1]"},
+		{"range(0)", "[]"},
+		{"range(9223372036854775806, 9223372036854775807, 5)", "[9223372036854775806]"},
+		{"range(4) |> filter(fn(x) { x > 1 })", "[2, 3]"},
+		{"map([1, true], fn(x) { -x })", "ERROR: " + UNKOWN_OPERATOR_ERROR_PREFIX + "-BOOLEAN"},
+		{"map([1], 1)", "ERROR: " + fmt.Sprintf(BUILTIN_ARGUMENT_TYPE_MISMATCH, 2, "map", object.FUNCTION_OBJECT, object.INTEGER_OBJECT)},
+		{"sort([1, \"a\"])", "ERROR: " + fmt.Sprintf(SORT_TYPE_MISMATCH, object.STRING_OBJECT)},
+		{"sort([1, 2], fn(a, b) { 1 })", "ERROR: " + fmt.Sprintf(SORT_COMPARATOR_TYPE, object.INTEGER_OBJECT)},
+		{"range(1, 2, 0)", "ERROR: " + RANGE_STEP_ZERO},
+		{"range()", "ERROR: " + fmt.Sprintf(BUILTIN_NUMBER_OF_ARGUMENT_RANGE_ERROR, 0, 1, 3)},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expect {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.expect, evaluated.Inspect())
+		}
+	}
+}
+
+func TestStringBuiltins(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect string
+	}{
+		{`split("a,b,,c", ",")`, "[a, b, , c]"},
+		{`split("日本語", "")`, "[日, 本, 語]"},
+		{`join(["a", "b", "c"], "-")`, "a-b-c"},
+		{`join([], "-")`, ""},
+		{`"a b".split(" ").join("_")`, "a_b"},
+		{`trim("  hello  ")`, "hello"},
+		{`upper("héllo")`, "HÉLLO"},
+		{`lower("ÀBC")`, "àbc"},
+		{`contains("gonkey", "onk")`, "true"},
+		{`contains("gonkey", "x")`, "false"},
+		{`replace("a-b-c", "-", "+")`, "a+b+c"},
+		{`starts_with("gonkey", "go")`, "true"},
+		{`ends_with("gonkey", "go")`, "false"},
+		{`index_of("gonkey", "key")`, "3"},
+		{`index_of("gonkey", "x")`, "-1"},
+		{`let s = "a=b"; s[index_of(s, "=") + 1:]`, "b"},
+		{`repeat("ab", 3)`, "ababab"},
+		{`repeat("ab", 0)`, ""},
+		{`chars("aあb")`, "[a, あ, b]"},
+		{`chars("")`, "[]"},
+		{`format("%s is %d years old", "gonkey", 3)`, "gonkey is 3 years old"},
+		{`format("%v %t %05d", [1, "a"], true, 42)`, "[1, a] true 00042"},
+		{`format("%d")`, "%!d(MISSING)"},
+		{`format("%-5d|%.3s|%[1]*[2]d", 3, 42)`, "3    |%!s(int64=042)| 42"},
+		{`len(format(repeat("%1000000d", 16), 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16))`, "16000000"},
+		{`format("%100000000d", 1)`, "ERROR: " + fmt.Sprintf(FORMAT_WIDTH_TOO_LARGE, maxStringLength)},
+		{`format("%.100000000d", 1)`, "ERROR: " + fmt.Sprintf(FORMAT_WIDTH_TOO_LARGE, maxStringLength)},
+		{`format("%99999999999999999999d", 1)`, "ERROR: " + fmt.Sprintf(FORMAT_WIDTH_TOO_LARGE, maxStringLength)},
+		{`format(repeat("%10000000d", 2), 1, 2)`, "ERROR: " + fmt.Sprintf(FORMAT_WIDTH_TOO_LARGE, maxStringLength)},
+		{`format("%s%s", repeat("a", 10000000), repeat("b", 10000000))`, "ERROR: " + fmt.Sprintf(STRING_TOO_LARGE, 20000000, maxStringLength)},
+		{`upper(1)`, "ERROR: " + fmt.Sprintf(BUILTIN_ARGUMENT_TYPE_MISMATCH, 1, "upper", object.STRING_OBJECT, object.INTEGER_OBJECT)},
+		{`replace("a", "b")`, "ERROR: " + fmt.Sprintf(BUILTIN_NUMBER_OF_ARGUMENT_ERROR, 2, 3)},
+		{`join(["a", 1], "")`, "ERROR: " + fmt.Sprintf(JOIN_ELEMENT_TYPE_MISMATCH, 1, object.INTEGER_OBJECT)},
+		{`repeat("a", -1)`, "ERROR: " + fmt.Sprintf(REPEAT_COUNT_NEGATIVE, -1)},
+		{`repeat("ab", 9223372036854775807)`, "ERROR: " + REPEAT_RESULT_TOO_LARGE},
+		{`repeat("a", 2000000000)`, "ERROR: " + REPEAT_RESULT_TOO_LARGE},
+		{`len(repeat("a", 16777216))`, "16777216"},
+		{`repeat("a", 16777216) + "a"`, "ERROR: " + fmt.Sprintf(STRING_TOO_LARGE, maxStringLength+1, maxStringLength)},
+		{`let s = repeat("a", 8388608); join([s, s, s], "")`, "ERROR: " + fmt.Sprintf(STRING_TOO_LARGE, 3*8388608, maxStringLength)},
+		{`replace(repeat("a", 1000000), "a", "aaaaaaaaaaaaaaaaaaaa")`, "ERROR: " + fmt.Sprintf(STRING_TOO_LARGE, 20000000, maxStringLength)},
+		{`format(1)`, "ERROR: " + fmt.Sprintf(BUILTIN_ARGUMENT_TYPE_MISMATCH, 1, "format", object.STRING_OBJECT, object.INTEGER_OBJECT)},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expect {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.expect, evaluated.Inspect())
+		}
+	}
+}
+
+func TestConvertBuiltins(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect string
+	}{
+		{`int("42")`, "42"},
+		{`int(" -7 ")`, "-7"},
+		{`int(5)`, "5"},
+		{`int(true)`, "1"},
+		{`int("4x")`, "ERROR: " + fmt.Sprintf(INTEGER_PARSE_ERROR, "4x")},
+		{`int("99999999999999999999")`, "ERROR: " + fmt.Sprintf(INTEGER_PARSE_ERROR, "99999999999999999999")},
+		{`int([1])`, "ERROR: " + fmt.Sprintf(BUILTIN_ARGUMENT_TYPE_ERRROR, "int", object.ARRAY_OBJ)},
+		{`str(42) + "!"`, "42!"},
+		{`str([1, "a"])`, "[1, a]"},
+		{`str("a")`, "a"},
+		{`bool("true")`, "true"},
+		{`bool("false")`, "false"},
+		{`bool("yes")`, "ERROR: " + fmt.Sprintf(BOOLEAN_PARSE_ERROR, "yes")},
+		{`bool(0)`, "true"},
+		{`bool(if (false) { 1 })`, "false"},
+		{`type(1)`, "INTEGER"},
+		{`type("a")`, "STRING"},
+		{`type({})`, "HASH"},
+		{`type(fn() {})`, "FUNCTION"},
+		{`type(len)`, "BUILTIN"},
+		{`is_error(int("x"))`, "true"},
+		{`is_error(int("1"))`, "false"},
+		{`is_error(1 + true)`, "true"},
+		{`is_error(undefined_function())`, "ERROR: " + IDENTIFIER_NOT_FOUND_ERROR_PREFIX + "undefined_function"},
+		{`let f = fn(x) { int(x) + 1 }; is_error(f("x"))`, "true"},
+		{`let parse = fn(s) { let n = int(s); if (is_error(n)) { 0 } else { n } }; parse("x")`, "ERROR: " + fmt.Sprintf(INTEGER_PARSE_ERROR, "x")},
+		{`let safe = fn(s) { if (is_error(int(s))) { 0 } else { int(s) } }; safe("x") + safe("2")`, "2"},
+		{`let is_error = fn(x) { "shadowed" }; is_error(1)`, "shadowed"},
+		{`int("x").is_error()`, "true"},
+		{`int("1").is_error()`, "false"},
+		{`int("x") |> is_error()`, "true"},
+		{`int("x") |> is_error`, "true"},
+		{`let check = is_error; check(int("x"))`, "true"},
+		{`int("x").len()`, "ERROR: " + fmt.Sprintf(INTEGER_PARSE_ERROR, "x")},
+		{`int("x") |> len()`, "ERROR: " + fmt.Sprintf(INTEGER_PARSE_ERROR, "x")},
+		{`{"is_error": fn(x) { x }}.is_error(int("x"))`, "ERROR: " + fmt.Sprintf(INTEGER_PARSE_ERROR, "x")},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expect {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.expect, evaluated.Inspect())
+		}
+	}
+}
+
+func TestMathModule(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect string
+	}{
+		{`math.abs(-3)`, "3"},
+		{`math.abs(3)`, "3"},
+		{`math.abs(-9223372036854775807 - 1)`, "ERROR: " + fmt.Sprintf(INTEGER_OVERFLOW, "abs")},
+		{`math.min(3, 1, 2)`, "1"},
+		{`math.max(3, 1, 2)`, "3"},
+		{`math.min([5, -2, 7])`, "-2"},
+		{`math.max([])`, "ERROR: " + fmt.Sprintf(MIN_MAX_EMPTY, "max")},
+		{`math.min(1, "a")`, "ERROR: " + fmt.Sprintf(MIN_MAX_ELEMENT_MISMATCH, 1, "min", object.STRING_OBJECT)},
+		{`math.pow(2, 10)`, "1024"},
+		{`math.pow(-3, 3)`, "-27"},
+		{`math.pow(5, 0)`, "1"},
+		{`math.pow(2, 62)`, "4611686018427387904"},
+		{`math.pow(2, 63)`, "ERROR: " + fmt.Sprintf(INTEGER_OVERFLOW, "pow")},
+		{`math.pow(2, -1)`, "ERROR: " + fmt.Sprintf(NEGATIVE_ARGUMENT, "pow", -1)},
+		{`math.sqrt(17)`, "4"},
+		{`math.sqrt(9223372036854775807)`, "3037000499"},
+		{`math.sqrt(-1)`, "ERROR: " + fmt.Sprintf(NEGATIVE_ARGUMENT, "sqrt", -1)},
+		{`math.floor(7, 2)`, "3"},
+		{`math.floor(-7, 2)`, "-4"},
+		{`math.ceil(7, 2)`, "4"},
+		{`math.ceil(-7, 2)`, "-3"},
+		{`math.floor(5)`, "5"},
+		{`math.ceil(1, 0)`, "ERROR: " + fmt.Sprintf(DIVISION_BY_ZERO, "ceil")},
+		{`math.clamp(15, 0, 10)`, "10"},
+		{`math.clamp(-5, 0, 10)`, "0"},
+		{`math.clamp(5, 0, 10)`, "5"},
+		{`math.clamp(5, 10, 0)`, "ERROR: " + fmt.Sprintf(CLAMP_BOUNDS_REVERSED, 10, 0)},
+		{`math.abs("a")`, "ERROR: " + fmt.Sprintf(BUILTIN_ARGUMENT_TYPE_MISMATCH, 1, "abs", object.INTEGER_OBJECT, object.STRING_OBJECT)},
+		{`math.random(0)`, "ERROR: " + fmt.Sprintf(RANDOM_RANGE_EMPTY, 0, 0)},
+		{`math.random(3, 4)`, "3"},
+		{`math["abs"](-1)`, "1"},
+		{`let m = math; m.max(1, 2)`, "2"},
+		{`let math = 1; math`, "1"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expect {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.expect, evaluated.Inspect())
+		}
+	}
+}
+
+func TestMathRandomSeed(t *testing.T) {
+	input := `[math.random(), math.random(100), math.random(-5, 5)]`
+	results := make([]string, 2)
+	for i := range results {
+		p := parser.New(tokenizer.New(input))
+		results[i] = New(WithRandomSeed(42)).Eval(p.ParseProgram()).Inspect()
+	}
+	if results[0] != results[1] {
+		t.Errorf("same seed produced different results: %q, %q", results[0], results[1])
+	}
+
+	p := parser.New(tokenizer.New(`let xs = map(range(100), fn(_) { math.random(-5, 5) }); [math.min(xs), math.max(xs)]`))
+	evaluated := New(WithRandomSeed(1)).Eval(p.ParseProgram())
+	if evaluated.Inspect() != "[-5, 4]" {
+		t.Errorf("random values out of range. got=%q", evaluated.Inspect())
+	}
+}
+
+func TestJSONParse(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect string
+	}{
+		{`{"b": 1, "a": [true, null, "x"], "c": {"z": -2, "y": "<&>"}}`, `{b: 1, a: [true, null, x], c: {z: -2, y: <&>}}`},
+		{` [1, 2] `, "[1, 2]"},
+		{`"\u3042"`, "あ"},
+		{`{"a": 1, "a": 2}`, "{a: 2}"},
+		{`{"a": 1,}`, "ERROR: " + fmt.Sprintf(JSON_PARSE_ERROR, 8, "invalid character '}' looking for beginning of object key string")},
+		{`{"a": 1 "b": 2}`, "ERROR: " + fmt.Sprintf(JSON_PARSE_ERROR, 8, "invalid character '\"' after object key:value pair")},
+		{`[1, 2`, "ERROR: " + fmt.Sprintf(JSON_PARSE_ERROR, 5, "unexpected end of JSON input")},
+		{`[1, 2.5]`, "ERROR: " + fmt.Sprintf(JSON_NUMBER_NOT_INTEGER, 4, "2.5")},
+		{`99999999999999999999`, "ERROR: " + fmt.Sprintf(JSON_NUMBER_NOT_INTEGER, 0, "99999999999999999999")},
+		{`{} {}`, "ERROR: " + fmt.Sprintf(JSON_PARSE_ERROR, 3, "invalid character '{' after top-level value")},
+		{`  [1, 2.5]`, "ERROR: " + fmt.Sprintf(JSON_NUMBER_NOT_INTEGER, 6, "2.5")},
+		{``, "ERROR: " + fmt.Sprintf(JSON_PARSE_ERROR, 0, "unexpected end of JSON input")},
+	}
+
+	for _, tt := range tests {
+		evaluated := New().builtinJSONParse(&object.String{Value: tt.input})
+		if evaluated.Inspect() != tt.expect {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.expect, evaluated.Inspect())
+		}
+	}
+}
+
+func TestJSONStringify(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect string
+	}{
+		{`json_stringify({"b": 1, "a": [true, if (false) { 1 }, "x<y>"]})`, `{"b":1,"a":[true,null,"x<y>"]}`},
+		{`json_stringify("a")`, `"a"`},
+		{`json_stringify([])`, "[]"},
+		{`json_stringify({"a": [1]}, 2)`, "{\n  \"a\": [\n    1\n  ]\n}"},
+		{`json_stringify([1], "	")`, "[\n\t1\n]"},
+		{`json_stringify([1], 0)`, "[1]"},
+		{`len(json_stringify([1], 100))`, "15"},
+		{`json_stringify([1], -1)`, "ERROR: " + fmt.Sprintf(JSON_INDENT_NEGATIVE, -1)},
+		{`json_stringify([1], "abcdefghijklmn")`, "[\nabcdefghij1\n]"},
+		{`json_stringify([1], "あいうえおかきくけこさ")`, "[\nあいうえおかきくけこ1\n]"},
+		{`json_stringify([1], true)`, "ERROR: " + fmt.Sprintf(BUILTIN_ARGUMENT_TYPE_MISMATCH, 2, "json_stringify", object.INTEGER_OBJECT+" or "+object.STRING_OBJECT, object.BOOLEAN_OBJECT)},
+		{`json_stringify({1: 2})`, "ERROR: " + fmt.Sprintf(JSON_UNSUPPORTED_KEY_TYPE, object.INTEGER_OBJECT)},
+		{`json_stringify([len])`, "ERROR: " + fmt.Sprintf(JSON_UNSUPPORTED_TYPE, object.BUILTIN_OBJ)},
+		{`let h = {"z": [1, {"y": "q"}], "a": true}; json_parse(json_stringify(h)) == h`, "false"},
+		{`let h = {"z": [1, {"y": "q"}], "a": true}; json_stringify(json_parse(json_stringify(h, 4)))`, `{"z":[1,{"y":"q"}],"a":true}`},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expect {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.expect, evaluated.Inspect())
+		}
+	}
+}
+
+func TestRegexBuiltins(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect string
+	}{
+		{`regex("a+b")`, "/a+b/"},
+		{`type(regex("a"))`, "REGEX"},
+		{`regex("(")`, "ERROR: " + fmt.Sprintf(REGEX_COMPILE_ERROR, "(", "error parsing regexp: missing closing ): `(`")},
+		{`regex(1)`, "ERROR: " + fmt.Sprintf(BUILTIN_ARGUMENT_TYPE_MISMATCH, 1, "regex", object.STRING_OBJECT, object.INTEGER_OBJECT)},
+		{`match("^\d+$", "123")`, "true"},
+		{`match("^\d+$", "12a")`, "false"},
+		{`let re = regex("err(or)?"); re.match("an error occurred")`, "true"},
+		{`match("(", "x")`, "ERROR: " + fmt.Sprintf(REGEX_COMPILE_ERROR, "(", "error parsing regexp: missing closing ): `(`")},
+		{`match(1, "x")`, "ERROR: " + fmt.Sprintf(BUILTIN_ARGUMENT_TYPE_MISMATCH, 1, "match", REGEX_ARGUMENT_PATTERN, object.INTEGER_OBJECT)},
+		{`match("a", 1)`, "ERROR: " + fmt.Sprintf(BUILTIN_ARGUMENT_TYPE_MISMATCH, 2, "match", object.STRING_OBJECT, object.INTEGER_OBJECT)},
+		{`find_all("\d+", "a1 b22 c333")`, "[1, 22, 333]"},
+		{`find_all("x", "abc")`, "[]"},
+		{`capture("(\w+)=(\d+)", "key=42 other=1")`, "[key=42, key, 42]"},
+		{`capture("(a)|(b)", "b")`, "[b, null, b]"},
+		{`capture("z", "abc")`, "null"},
+		{`let line = "2024-01-02 ERROR disk full"; let m = regex("^(\S+) (\w+) (.*)$").capture(line); m[2] + ": " + m[3]`, "ERROR: disk full"},
+		{`replace_all("\d", "a1b2", "#")`, "a#b#"},
+		{`replace_all("(\w+)@(\w+)", "me@host", "$2 at $1")`, "host at me"},
+		{`replace_all("(?P<user>\w+)@\w+", "me@host", "${user}")`, "me"},
+		{`replace_all("\d+", "a1b22", fn(m) { str(int(m) * 2) })`, "a2b44"},
+		{`replace_all("\d+", "a1b22", fn(m) { 1 })`, "ERROR: " + fmt.Sprintf(REPLACE_RESULT_TYPE, object.INTEGER_OBJECT)},
+		{`replace_all("\d+", "a1b22", fn(m) { m + 1 })`, "ERROR: type mismatch: STRING + INTEGER"},
+		{`replace_all("a", "b", 1)`, "ERROR: " + fmt.Sprintf(BUILTIN_ARGUMENT_TYPE_MISMATCH, 3, "replace_all", object.STRING_OBJECT, object.INTEGER_OBJECT)},
+		{`replace_all("a", repeat("a", 1000000), repeat("b", 20))`, "ERROR: " + fmt.Sprintf(STRING_TOO_LARGE, 20000000, maxStringLength)},
+		{`replace_all("(a)", repeat("a", 1000000), "$1$1$1$1$1$1$1$1$1$1$1$1$1$1$1$1$1$1$1$1")`, "ERROR: " + fmt.Sprintf(STRING_TOO_LARGE, 20000000, maxStringLength)},
+		{`replace_all("a", repeat("a", 100000), fn(m) { repeat("b", 200) })`, "ERROR: " + fmt.Sprintf(STRING_TOO_LARGE, 16777394, maxStringLength)},
+		{`len(replace_all("a", repeat("a", 100000), repeat("b", 20)))`, "2000000"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expect {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.expect, evaluated.Inspect())
+		}
+	}
+}
+
+func TestRegexCache(t *testing.T) {
+	c := newRegexCache()
+	first, err := c.compile("a+")
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+	second, _ := c.compile("a+")
+	if first != second {
+		t.Errorf("pattern was compiled twice")
+	}
+
+	for i := 0; i < maxCachedPatterns; i++ {
+		c.compile(fmt.Sprintf("b%d", i))
+	}
+	if len(c.patterns) > maxCachedPatterns {
+		t.Errorf("cache grew beyond limit. got=%d", len(c.patterns))
+	}
+}
+
+func TestFileBuiltins(t *testing.T) {
+	root := t.TempDir()
+	other := t.TempDir()
+	outside := t.TempDir()
+	os.Mkdir(filepath.Join(root, "logs"), 0o755)
+	os.WriteFile(filepath.Join(root, "logs", "b.log"), []byte("second"), 0o644)
+	os.WriteFile(filepath.Join(root, "logs", "a.log"), []byte("first"), 0o644)
+	os.WriteFile(filepath.Join(other, "shared.txt"), []byte("shared"), 0o644)
+	os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0o644)
+	os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(root, "link.txt"))
+	os.Symlink(outside, filepath.Join(root, "linkdir"))
+	os.Symlink(filepath.Join(outside, "created.txt"), filepath.Join(root, "dangling.txt"))
+
+	fsys, err := DirFS(root, other)
+	if err != nil {
+		t.Fatalf("DirFS error: %s", err)
+	}
+
+	denied := func(name, path string) string {
+		return "ERROR: " + fmt.Sprintf(FILE_OPERATION_ERROR, name, path, "permission denied")
+	}
+	sharedPath := filepath.Join(other, "shared.txt")
+	secretPath := filepath.Join(outside, "secret.txt")
+
+	tests := []struct {
+		input  string
+		expect string
+	}{
+		{`read_file("logs/a.log")`, "first"},
+		{`list_dir("logs")`, "[a.log, b.log]"},
+		{`write_file("logs/c.log", "third"); read_file("logs/c.log")`, "third"},
+		{`[exists("logs/a.log"), exists("missing.txt")]`, "[true, false]"},
+		{`read_file("` + sharedPath + `")`, "shared"},
+		{`read_file("missing.txt")`, "ERROR: " + fmt.Sprintf(FILE_OPERATION_ERROR, "read_file", "missing.txt", "no such file or directory")},
+		{`read_file("../` + filepath.Base(outside) + `/secret.txt")`, denied("read_file", "../"+filepath.Base(outside)+"/secret.txt")},
+		{`read_file("` + secretPath + `")`, denied("read_file", secretPath)},
+		{`exists("` + secretPath + `")`, denied("exists", secretPath)},
+		{`read_file("link.txt")`, denied("read_file", "link.txt")},
+		{`list_dir("linkdir")`, denied("list_dir", "linkdir")},
+		{`write_file("linkdir/new.txt", "x")`, denied("write_file", "linkdir/new.txt")},
+		{`write_file("dangling.txt", "x")`, denied("write_file", "dangling.txt")},
+		{`read_file(1)`, "ERROR: " + fmt.Sprintf(BUILTIN_ARGUMENT_TYPE_MISMATCH, 1, "read_file", object.STRING_OBJECT, object.INTEGER_OBJECT)},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(tokenizer.New(tt.input))
+		evaluated := New(WithFileSystem(fsys)).Eval(p.ParseProgram())
+		if evaluated.Inspect() != tt.expect {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.expect, evaluated.Inspect())
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(outside, "created.txt")); err == nil {
+		t.Errorf("file was created outside of the sandbox")
+	}
+	if _, err := DirFS(filepath.Join(root, "logs", "a.log")); err == nil {
+		t.Errorf("DirFS accepted a regular file as root")
+	}
+
+	rootFS, err := DirFS("/")
+	if err != nil {
+		t.Fatalf("DirFS error: %s", err)
+	}
+	input := `read_file("` + secretPath + `")`
+	evaluated := New(WithFileSystem(rootFS)).Eval(parser.New(tokenizer.New(input)).ParseProgram())
+	if evaluated.Inspect() != "secret" {
+		t.Errorf("%s with root /: wrong result. want=%q, got=%q", input, "secret", evaluated.Inspect())
+	}
+}
+
+func TestFileBuiltinsDisabled(t *testing.T) {
+	for _, name := range []string{"read_file", "write_file", "list_dir", "exists"} {
+		evaluated := testEval(name + `("a", "b")`)
+		expect := "ERROR: " + fmt.Sprintf(FILE_SYSTEM_DISABLED, name)
+		if evaluated.Inspect() != expect {
+			t.Errorf("%s: wrong result. want=%q, got=%q", name, expect, evaluated.Inspect())
+		}
+	}
+}
+
+func TestReadOnlyFS(t *testing.T) {
+	fsys := ReadOnlyFS(fstest.MapFS{
+		"conf/app.json": {Data: []byte("{}")},
+		"readme.txt":    {Data: []byte("hello")},
+	})
+
+	tests := []struct {
+		input  string
+		expect string
+	}{
+		{`read_file("readme.txt")`, "hello"},
+		{`read_file("/conf/app.json")`, "{}"},
+		{`read_file("../readme.txt")`, "hello"},
+		{`list_dir("/")`, "[conf, readme.txt]"},
+		{`[exists("conf"), exists("nope")]`, "[true, false]"},
+		{`write_file("readme.txt", "x")`, "ERROR: " + fmt.Sprintf(FILE_OPERATION_ERROR, "write_file", "readme.txt", "permission denied")},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(tokenizer.New(tt.input))
+		evaluated := New(WithFileSystem(fsys)).Eval(p.ParseProgram())
+		if evaluated.Inspect() != tt.expect {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.expect, evaluated.Inspect())
+		}
+	}
+}
+
+func TestInputBuiltins(t *testing.T) {
+	tests := []struct {
+		input  string
+		stdin  string
+		expect string
+	}{
+		{`gets()`, "a\nb\n", "a\n"},
+		{`[read_line(), read_line(), read_line()]`, "a\r\n\nb", "[a, , b]"},
+		{`[gets(), gets()]`, "", "[null, null]"},
+		{`[read_line(), read_all()]`, "first\nsecond\nthird\n", "[first, second\nthird\n]"},
+		{`[read_all(), read_all(), read_line()]`, "x", "[x, , null]"},
+		{`let count = fn(n) { if (read_line()) { count(n + 1) } else { n } }; count(0)`, "1\n2\n3\n", "3"},
+		{`read_line(1)`, "", "ERROR: " + fmt.Sprintf(BUILTIN_NUMBER_OF_ARGUMENT_ERROR, 1, 0)},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(tokenizer.New(tt.input))
+		evaluated := New(WithStdin(strings.NewReader(tt.stdin))).Eval(p.ParseProgram())
+		if evaluated.Inspect() != tt.expect {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.expect, evaluated.Inspect())
+		}
+	}
+}
+
+func TestArgs(t *testing.T) {
+	tests := []struct {
+		input  string
+		args   []string
+		expect string
+	}{
+		{`args`, nil, "[]"},
+		{`args`, []string{"-n", "file.txt"}, "[-n, file.txt]"},
+		{`len(args)`, []string{"a"}, "1"},
+		{`let f = fn(args) { args }; f(1)`, []string{"a"}, "1"},
+		{`let args = 2; args`, []string{"a"}, "2"},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(tokenizer.New(tt.input))
+		evaluated := New(WithArgs(tt.args)).Eval(p.ParseProgram())
+		if evaluated.Inspect() != tt.expect {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.expect, evaluated.Inspect())
+		}
+	}
+}
+
+func TestLookupValue(t *testing.T) {
+	in := New(WithArgs([]string{"a"}))
+
+	args, ok := in.LookupValue("args")
+	if !ok || args.Inspect() != "[a]" {
+		t.Errorf("wrong args. got=%v, %t", args, ok)
+	}
+	if _, ok := in.LookupValue("math"); !ok {
+		t.Errorf("math not found")
+	}
+	if _, ok := in.LookupValue("undefined"); ok {
+		t.Errorf("undefined value found")
+	}
+
+	if _, ok := in.LookupModule("math"); !ok {
+		t.Errorf("math module not found")
+	}
+	if _, ok := in.LookupModule("args"); ok {
+		t.Errorf("args found as a module")
+	}
+}
+
+func TestTimeBuiltins(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		input  string
+		expect string
+	}{
+		{`now()`, fmt.Sprint(start.UnixMilli())},
+		{`format_time(now())`, "2024-03-01T12:00:00.000Z"},
+		{`let t = now(); sleep(1500); now() - t`, "1500"},
+		{`sleep(duration("2h")); format_time(now(), "2006-01-02 15:04")`, "2024-03-01 14:00"},
+		{`format_time(now() + duration("36h"), "Jan 2")`, "Mar 3"},
+		{`parse_time("2024-03-01T12:00:00Z") == now()`, "true"},
+		{`parse_time("2024-03-01T21:00:00.250+09:00") - now()`, "250"},
+		{`parse_time(format_time(now())) == now()`, "true"},
+		{`parse_time("01/02/2006", "01/02/2006")`, "1136160000000"},
+		{`parse_time("yesterday")`, "ERROR: " + fmt.Sprintf(TIME_PARSE_ERROR, "yesterday", time.RFC3339)},
+		{`duration("1h30m")`, "5400000"},
+		{`duration("1500us")`, "1"},
+		{`duration("soon")`, "ERROR: " + fmt.Sprintf(DURATION_PARSE_ERROR, "soon")},
+		{`format_duration(duration("1h30m") + 250)`, "1h30m0.25s"},
+		{`format_duration(9223372036854775807)`, "ERROR: " + fmt.Sprintf(DURATION_OUT_OF_RANGE, int64(9223372036854775807))},
+		{`sleep(-1)`, "ERROR: " + fmt.Sprintf(SLEEP_NEGATIVE, -1)},
+		{`sleep("1s")`, "ERROR: " + fmt.Sprintf(BUILTIN_ARGUMENT_TYPE_MISMATCH, 1, "sleep", object.INTEGER_OBJECT, object.STRING_OBJECT)},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(tokenizer.New(tt.input))
+		evaluated := New(WithClock(NewFakeClock(start))).Eval(p.ParseProgram())
+		if evaluated.Inspect() != tt.expect {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.expect, evaluated.Inspect())
+		}
+	}
+}
+
+func TestSleepStopsOnTimeout(t *testing.T) {
+	p := parser.New(tokenizer.New(`is_error(sleep(60000))`))
+	began := time.Now()
+	evaluated := New(WithTimeout(20 * time.Millisecond)).Eval(p.ParseProgram())
+	if time.Since(began) > 10*time.Second {
+		t.Fatalf("sleep ignored the timeout")
+	}
+	err, ok := evaluated.(*object.Error)
+	if !ok || err.Kind != object.TIMEOUT_ERROR || err.Message != EXECUTION_TIMEOUT {
+		t.Errorf("wrong result. got=%T (%+v)", evaluated, evaluated)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p = parser.New(tokenizer.New(`sleep(1)`))
+	evaluated = New(WithClock(NewFakeClock(time.Time{}))).EvalContext(ctx, p.ParseProgram())
+	if err, ok := evaluated.(*object.Error); !ok || err.Kind != object.CANCELED_ERROR {
+		t.Errorf("wrong result. got=%T (%+v)", evaluated, evaluated)
+	}
+}
+
 func TestArrayLiteral(t *testing.T) {
 	input := `[1, 2 * 2, "hello"]`
 	evaluated := testEval(input)
@@ -347,6 +1440,12 @@ func TestArrayIndexExpression(t *testing.T) {
 		{`let a = [1,2,3]; a[1]`, 2},
 		{`[1,2,3][1+1]`, 3},
 		{`[1,2,3][3]`, nil},
+		{`[1,2,3][-1]`, 3},
+		{`[1,2,3][-3]`, 1},
+		{`[1,2,3][-4]`, nil},
+		{`"abc"[0]`, "a"},
+		{`"abc"[-1]`, "c"},
+		{`"abc"[3]`, nil},
 	}
 
 	for _, tt := range tests {
@@ -362,6 +1461,55 @@ func TestArrayIndexExpression(t *testing.T) {
 	}
 }
 
+func TestSliceExpression(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect any
+	}{
+		{`[1,2,3,4][1:3]`, []int{2, 3}},
+		{`[1,2,3,4][:2]`, []int{1, 2}},
+		{`[1,2,3,4][2:]`, []int{3, 4}},
+		{`[1,2,3,4][:]`, []int{1, 2, 3, 4}},
+		{`[1,2,3,4][-2:]`, []int{3, 4}},
+		{`[1,2,3,4][:-1]`, []int{1, 2, 3}},
+		{`[1,2,3,4][1:100]`, []int{2, 3, 4}},
+		{`[1,2,3,4][-100:1]`, []int{1}},
+		{`[1,2,3,4][3:1]`, []int{}},
+		{`let a = [1,2,3]; let b = 1; a[b:b+1]`, []int{2}},
+		{`"hello"[1:3]`, "el"},
+		{`"hello"[:-1]`, "hell"},
+		{`"hello"[10:]`, ""},
+		{`1[1:2]`, SLICE_TYPE_MISMATCH + "INTEGER"},
+		{`[1]["a":]`, SLICE_INDEX_TYPE_MISMATCH + "STRING"},
+		{`[1][:foo]`, IDENTIFIER_NOT_FOUND_ERROR_PREFIX + "foo"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expect := tt.expect.(type) {
+		case []int:
+			arr, ok := evaluated.(*object.Array)
+			if !ok {
+				t.Fatalf("object is not Array. got=%T (%+v)", evaluated, evaluated)
+			}
+			if len(expect) != len(arr.Elements) {
+				t.Fatalf("wrong length of array. want=%d, got=%d", len(expect), len(arr.Elements))
+			}
+			for i, a := range arr.Elements {
+				testIntegerObject(t, a, int64(expect[i]))
+			}
+		case string:
+			if errObj, ok := evaluated.(*object.Error); ok {
+				if errObj.Message != expect {
+					t.Fatalf("wrong error message. want=%q, got=%q", expect, errObj.Message)
+				}
+				continue
+			}
+			testStringObject(t, evaluated, expect)
+		}
+	}
+}
+
 func TestHashLiterals(t *testing.T) {
 	input := `let two = "two";
 {
@@ -426,6 +1574,106 @@ func TestHashIndexExpression(t *testing.T) {
 	}
 }
 
+func TestPropertyExpression(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect any
+	}{
+		{`{"name": "gonkey"}.name`, "gonkey"},
+		{`let h = {"a": {"b": 2}}; h.a.b`, 2},
+		{`{"name": "gonkey"}.age`, nil},
+		{`let xs = [1]; xs.first`, PROPERTY_NOT_SUPPORTED + "ARRAY"},
+		{`1.foo`, PROPERTY_NOT_SUPPORTED + "INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expect := tt.expect.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expect))
+		case string:
+			if errObj, ok := evaluated.(*object.Error); ok {
+				if errObj.Message != expect {
+					t.Fatalf("wrong error message. want=%q, got=%q", expect, errObj.Message)
+				}
+				continue
+			}
+			testStringObject(t, evaluated, expect)
+		case nil:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestMethodCall(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect any
+	}{
+		{`[1, 2].push(3).len()`, 3},
+		{`"hello".len()`, 5},
+		{`let xs = [1, 2, 3]; xs.rest().first()`, 2},
+		{`let h = {"double": fn(x) { x * 2 }}; h.double(4)`, 8},
+		{`let h = {"len": fn() { 100 }}; h.len()`, 100},
+		{`[1].foo()`, UNDEFINED_METHOD + "ARRAY.foo"},
+		{`1.len()`, fmt.Sprintf(BUILTIN_ARGUMENT_TYPE_ERRROR, "len", object.INTEGER_OBJECT)},
+		{`[].push()`, fmt.Sprintf(BUILTIN_NUMBER_OF_ARGUMENT_ERROR, 1, 2)},
+		{`{"f": 1}.f()`, NOT_FUNCTION_ERROR + "INTEGER"},
+		{`[].push(foo)`, IDENTIFIER_NOT_FOUND_ERROR_PREFIX + "foo"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expect := tt.expect.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expect))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Fatalf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+			}
+			if errObj.Message != expect {
+				t.Fatalf("wrong error message. want=%q, got=%q", expect, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestPipeExpression(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect any
+	}{
+		{`[1, 2] |> push(3) |> len()`, 3},
+		{`let double = fn(x) { x * 2 }; 5 |> double`, 10},
+		{`5 |> fn(x) { x + 1 }`, 6},
+		{`let sub = fn(a, b) { a - b }; 10 |> sub(3)`, 7},
+		{`let sub = fn(a, b) { a - b }; 10 |> sub(3) |> sub(2)`, 5},
+		{`let m = {"sub": fn(a, b) { a - b }}; 10 |> m.sub(4)`, 6},
+		{`let double = fn(x) { x * 2 }; 1 + 2 |> double`, 6},
+		{`[1, 2] |> rest() |> first()`, 2},
+		{`1 |> 2`, NOT_FUNCTION_ERROR + "INTEGER"},
+		{`foo |> len()`, IDENTIFIER_NOT_FOUND_ERROR_PREFIX + "foo"},
+		{`1 |> len()`, fmt.Sprintf(BUILTIN_ARGUMENT_TYPE_ERRROR, "len", object.INTEGER_OBJECT)},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expect := tt.expect.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expect))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Fatalf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+			}
+			if errObj.Message != expect {
+				t.Fatalf("wrong error message. want=%q, got=%q", expect, errObj.Message)
+			}
+		}
+	}
+}
+
 func testEval(input string) object.Object {
 	p := parser.New(tokenizer.New(input))
 	program := p.ParseProgram()
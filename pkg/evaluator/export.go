@@ -0,0 +1,36 @@
+package evaluator
+
+import "github.com/oteto/gonkey/pkg/object"
+
+// 以下は tree-walker 以外の実行エンジン（VM など）と演算の意味を共有するための関数
+
+// 前置演算子を適用する
+func ApplyPrefixOperator(operator string, right object.Object) object.Object {
+	return evalPrefixExpression(operator, right)
+}
+
+// 中置演算子を適用する
+func ApplyInfixOperator(operator string, left, right object.Object) object.Object {
+	return evalInfixExpression(operator, left, right)
+}
+
+// left[index] を返す
+func Index(left, index object.Object) object.Object {
+	return evalIndexExpression(left, index)
+}
+
+// left[low:high] を返す
+// low, high が nil の場合は省略されたものとして扱う
+func Slice(left, low, high object.Object) object.Object {
+	return sliceObject(left, low, high)
+}
+
+// left.name を返す
+func Property(left object.Object, name string) object.Object {
+	return evalPropertyExpression(left, name)
+}
+
+// 条件式として真とみなされるかどうか
+func IsTruthy(obj object.Object) bool {
+	return isTruthy(obj)
+}
@@ -0,0 +1,185 @@
+package evaluator
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// スクリプトに使わせるファイルシステム
+// read_file, write_file, list_dir, exists はこのインターフェースを通してだけファイルに触れる
+type FileSystem interface {
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte) error
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Stat(name string) (fs.FileInfo, error)
+}
+
+// fs.FS を読み取り専用の FileSystem にする
+// パスは fs.FS と同じく / 区切りの相対パスで、先頭の / は取り除く
+func ReadOnlyFS(fsys fs.FS) FileSystem {
+	return &readOnlyFS{fsys: fsys}
+}
+
+type readOnlyFS struct {
+	fsys fs.FS
+}
+
+func (r *readOnlyFS) name(op, name string) (string, error) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		name = "."
+	}
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return name, nil
+}
+
+func (r *readOnlyFS) ReadFile(name string) ([]byte, error) {
+	name, err := r.name("read", name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.ReadFile(r.fsys, name)
+}
+
+func (r *readOnlyFS) WriteFile(name string, data []byte) error {
+	return &fs.PathError{Op: "write", Path: name, Err: fs.ErrPermission}
+}
+
+func (r *readOnlyFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	name, err := r.name("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.ReadDir(r.fsys, name)
+}
+
+func (r *readOnlyFS) Stat(name string) (fs.FileInfo, error) {
+	name, err := r.name("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Stat(r.fsys, name)
+}
+
+// roots 以下のディレクトリだけを読み書きできる FileSystem を作る
+// 相対パスは最初のディレクトリからのパスとして扱う
+// シンボリックリンクは解決してから判定するので、リンクを辿って roots の外に出ることはできない
+// ただし判定と操作の間にリンクを置き換えられる環境では使わない
+func DirFS(roots ...string) (FileSystem, error) {
+	if len(roots) == 0 {
+		return nil, errors.New("no root directories")
+	}
+	d := &dirFS{}
+	for _, root := range roots {
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			return nil, err
+		}
+		real, err := filepath.EvalSymlinks(abs)
+		if err != nil {
+			return nil, err
+		}
+		info, err := os.Stat(real)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, &fs.PathError{Op: "open", Path: root, Err: errors.New("not a directory")}
+		}
+		d.roots = append(d.roots, real)
+	}
+	return d, nil
+}
+
+type dirFS struct {
+	roots []string
+}
+
+// スクリプトが渡したパスを、roots の中の実際のパスにする
+func (d *dirFS) resolve(op, name string) (string, error) {
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(d.roots[0], name)
+	}
+	real, err := evalExistingSymlinks(filepath.Clean(name))
+	if err != nil {
+		return "", &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	for _, root := range d.roots {
+		if within(root, real) {
+			return real, nil
+		}
+	}
+	return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+}
+
+// path が root 自身か root の下にあるか
+// root が "/" のように区切り文字で終わっていても判定できるよう filepath.Rel を使う
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// 存在する部分のシンボリックリンクを解決し、存在しない残りをつなげる
+func evalExistingSymlinks(name string) (string, error) {
+	real, err := filepath.EvalSymlinks(name)
+	if err == nil {
+		return real, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	// 存在しない先を指すリンクは、書き込むと roots の外にファイルを作れるので許可しない
+	if _, err := os.Lstat(name); err == nil {
+		return "", fs.ErrPermission
+	}
+	parent := filepath.Dir(name)
+	if parent == name {
+		return name, nil
+	}
+	realParent, err := evalExistingSymlinks(parent)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(realParent, filepath.Base(name)), nil
+}
+
+func (d *dirFS) ReadFile(name string) ([]byte, error) {
+	real, err := d.resolve("read", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(real)
+}
+
+func (d *dirFS) WriteFile(name string, data []byte) error {
+	real, err := d.resolve("write", name)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(real, data, 0o644)
+}
+
+func (d *dirFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	real, err := d.resolve("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadDir(real)
+}
+
+func (d *dirFS) Stat(name string) (fs.FileInfo, error) {
+	real, err := d.resolve("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(real)
+}
@@ -0,0 +1,191 @@
+package evaluator
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/oteto/gonkey/pkg/ast"
+	"github.com/oteto/gonkey/pkg/object"
+)
+
+type config struct {
+	stdout io.Writer
+	stderr io.Writer
+	stdin  io.Reader
+
+	maxSteps       int
+	maxAllocations int
+	maxDepth       int
+	timeout        time.Duration
+
+	randomSeed int64
+	fileSystem FileSystem
+	args       []string
+	clock      Clock
+}
+
+type Option func(*config)
+
+// puts などの出力先（デフォルトは os.Stdout）
+func WithStdout(w io.Writer) Option {
+	return func(c *config) {
+		c.stdout = w
+	}
+}
+
+// エラー出力先（デフォルトは os.Stderr）
+func WithStderr(w io.Writer) Option {
+	return func(c *config) {
+		c.stderr = w
+	}
+}
+
+// 入力元（デフォルトは os.Stdin）
+func WithStdin(r io.Reader) Option {
+	return func(c *config) {
+		c.stdin = r
+	}
+}
+
+// math.random の種（デフォルトは現在時刻）
+// 同じ種を指定すると、同じスクリプトは同じ乱数列を使う
+func WithRandomSeed(seed int64) Option {
+	return func(c *config) {
+		c.randomSeed = seed
+	}
+}
+
+// スクリプトから args で参照するコマンドライン引数（デフォルトは空の配列）
+func WithArgs(args []string) Option {
+	return func(c *config) {
+		c.args = args
+	}
+}
+
+// now と sleep で使う時計（デフォルトは実際の時刻）
+// テストでは NewFakeClock で作った時計を渡すと、sleep で実際には待たない
+func WithClock(clock Clock) Option {
+	return func(c *config) {
+		c.clock = clock
+	}
+}
+
+// read_file などで使うファイルシステム（デフォルトは nil で、ファイルに触れる組み込み関数はエラーを返す）
+// 信頼できないスクリプトには DirFS や ReadOnlyFS で範囲を限ったものを渡す
+func WithFileSystem(fsys FileSystem) Option {
+	return func(c *config) {
+		c.fileSystem = fsys
+	}
+}
+
+// スクリプトを評価する
+// 標準入出力・組み込み関数・グローバル変数をインスタンスごとに持つので、
+// インスタンスごとに別の goroutine から同時に使える
+// １つのインスタンスや、評価中の ast.Program を複数の goroutine で共有してはいけない
+type Interpreter struct {
+	config   *config
+	builtins map[string]*object.Builtin
+	values   map[string]object.Object // math や args のような組み込みの値
+	env      *object.Environment
+	random   *rand.Rand
+	regexps  *regexCache
+	input    *bufio.Reader // 行単位で読むため stdin をバッファする
+
+	// 評価中の状態。組み込み関数から実行制限を確認するために使う
+	// VM で実行している場合は nil
+	current *state
+}
+
+func New(opts ...Option) *Interpreter {
+	c := &config{stdout: os.Stdout, stderr: os.Stderr, stdin: os.Stdin, randomSeed: time.Now().UnixNano(), clock: systemClock{}}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	in := &Interpreter{
+		config:  c,
+		env:     object.NewEnvironment(),
+		random:  rand.New(rand.NewSource(c.randomSeed)),
+		regexps: newRegexCache(),
+		input:   bufio.NewReader(c.stdin),
+	}
+	in.builtins = in.newBuiltins()
+	in.values = in.newValues()
+	return in
+}
+
+// グローバル環境で評価する
+// 前回の評価で定義したグローバル変数を引き継ぐ
+func (in *Interpreter) Eval(node ast.Node) object.Object {
+	return in.EvalContext(context.Background(), node)
+}
+
+// ctx がキャンセルされるか、オプションで指定した上限を超えると評価を止めて、
+// 上限の種類を Kind に持つ *object.Error を返す
+func (in *Interpreter) EvalContext(ctx context.Context, node ast.Node) object.Object {
+	return in.eval(ctx, node, in.env)
+}
+
+// グローバル変数を保持する環境
+func (in *Interpreter) Env() *object.Environment {
+	return in.env
+}
+
+// 組み込み関数を登録する。同じ名前の組み込み関数は置き換える
+func (in *Interpreter) Register(name string, fn object.BuiltinFunction) {
+	in.builtins[name] = &object.Builtin{Fn: fn}
+}
+
+// 組み込み関数を名前で探す
+func (in *Interpreter) LookupBuiltin(name string) (*object.Builtin, bool) {
+	builtin, ok := in.builtins[name]
+	return builtin, ok
+}
+
+// math や args のような組み込みの値を名前で探す
+func (in *Interpreter) LookupValue(name string) (object.Object, bool) {
+	value, ok := in.values[name]
+	return value, ok
+}
+
+// 組み込みの値のうち、math のように組み込み関数をまとめた名前空間を名前で探す
+//
+// Deprecated: 名前空間以外の値も探せる LookupValue を使う
+func (in *Interpreter) LookupModule(name string) (*object.Hash, bool) {
+	module, ok := in.values[name].(*object.Hash)
+	return module, ok
+}
+
+// receiver.name(...) で呼び出す関数と、引数リストの先頭に置く値を返す
+func (in *Interpreter) LookupMethod(receiver object.Object, name string) (object.Object, []object.Object) {
+	return in.lookupMethod(receiver, name)
+}
+
+func (in *Interpreter) eval(ctx context.Context, node ast.Node, env *object.Environment) object.Object {
+	if in.config.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, in.config.timeout)
+		defer cancel()
+	}
+
+	s := newState(ctx, in)
+	prev := in.current
+	in.current = s
+	defer func() { in.current = prev }()
+
+	return s.eval(node, env)
+}
+
+// 制限なしで env を使って評価する。組み込み関数の出力先は os.Stdout
+func Eval(node ast.Node, env *object.Environment) object.Object {
+	return New().eval(context.Background(), node, env)
+}
+
+// opts の制限のもとで env を使って評価する
+func EvalContext(ctx context.Context, node ast.Node, env *object.Environment, opts ...Option) object.Object {
+	return New(opts...).eval(ctx, node, env)
+}
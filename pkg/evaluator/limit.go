@@ -0,0 +1,215 @@
+package evaluator
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/oteto/gonkey/pkg/object"
+)
+
+const (
+	STEP_LIMIT_EXCEEDED       = "step limit exceeded: %d"
+	ALLOCATION_LIMIT_EXCEEDED = "allocation limit exceeded: %d"
+	DEPTH_LIMIT_EXCEEDED      = "call depth limit exceeded: %d"
+	NESTING_LIMIT_EXCEEDED    = "evaluation nested too deeply: %d"
+	EXECUTION_TIMEOUT         = "execution timed out"
+	EXECUTION_CANCELED        = "execution canceled"
+)
+
+// context を確認する間隔（ステップ数）
+const contextCheckInterval = 1024
+
+// 評価するノードの数の上限
+func WithMaxSteps(n int) Option {
+	return func(c *config) {
+		c.maxSteps = n
+	}
+}
+
+// 評価中に生成するオブジェクト（関数呼び出しごとの環境を含む）の数の上限
+func WithMaxAllocations(n int) Option {
+	return func(c *config) {
+		c.maxAllocations = n
+	}
+}
+
+// 関数呼び出しの深さのデフォルトの上限
+// 末尾呼び出しは深さに数えない。Go のスタックが溢れてプロセスが落ちる前に止める
+// 呼び出し１段で Go のスタックを 4KB ほど（-race では 6KB ほど）使うので、
+// ホストが debug.SetMaxStack で上限を下げていても溢れないよう余裕を持たせる
+const DefaultMaxDepth = 10000
+
+// 関数呼び出しの深さの上限（デフォルトは DefaultMaxDepth、0 以下の場合もデフォルトを使う）
+// 他の上限と違って無制限にはできない
+func WithMaxDepth(n int) Option {
+	return func(c *config) {
+		c.maxDepth = n
+	}
+}
+
+// 関数呼び出しの深さの上限。VM も同じ上限を使う
+func (in *Interpreter) MaxDepth() int {
+	if in.config.maxDepth <= 0 {
+		return DefaultMaxDepth
+	}
+	return in.config.maxDepth
+}
+
+// 実行時間の上限
+func WithTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.timeout = d
+	}
+}
+
+// 1 回の評価の状態
+type state struct {
+	ctx    context.Context
+	interp *Interpreter
+
+	steps       int
+	allocations int
+	depth       int // 評価中の（末尾呼び出しでない）関数呼び出しの深さ
+	nesting     int // 評価中の eval の再帰の深さ
+
+	// 上限を超えたときのエラー。以降の評価はすべてこのエラーを返す
+	err *object.Error
+}
+
+func newState(ctx context.Context, in *Interpreter) *state {
+	return &state{ctx: ctx, interp: in}
+}
+
+// ノードを１つ評価するごとに呼ばれる
+func (s *state) step() *object.Error {
+	if s.err != nil {
+		return s.err
+	}
+
+	s.steps++
+	if s.interp.config.maxSteps > 0 && s.steps > s.interp.config.maxSteps {
+		return s.fail(object.STEP_LIMIT_ERROR, STEP_LIMIT_EXCEEDED, s.interp.config.maxSteps)
+	}
+
+	if s.steps%contextCheckInterval == 0 && s.ctx.Err() != nil {
+		s.err = contextError(s.ctx)
+		return s.err
+	}
+	return nil
+}
+
+// 終了した ctx の理由を実行制限のエラーにする
+func contextError(ctx context.Context) *object.Error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &object.Error{Message: EXECUTION_TIMEOUT, Kind: object.TIMEOUT_ERROR}
+	}
+	return &object.Error{Message: EXECUTION_CANCELED, Kind: object.CANCELED_ERROR}
+}
+
+// n 個のオブジェクトを生成したことを記録する
+func (s *state) allocate(n int) *object.Error {
+	if s.err != nil {
+		return s.err
+	}
+
+	s.allocations += n
+	if s.interp.config.maxAllocations > 0 && s.allocations > s.interp.config.maxAllocations {
+		return s.fail(object.ALLOCATION_LIMIT_ERROR, ALLOCATION_LIMIT_EXCEEDED, s.interp.config.maxAllocations)
+	}
+	return nil
+}
+
+// 組み込み関数が n 個の値を生成する前に呼ばれる
+// 残りの生成数を超える場合は生成せずにエラーにする。実行時間の上限とキャンセルも確認する
+func (s *state) reserve(n int) *object.Error {
+	if s.err != nil {
+		return s.err
+	}
+
+	max := s.interp.config.maxAllocations
+	if max > 0 && n > max-s.allocations {
+		return s.fail(object.ALLOCATION_LIMIT_ERROR, ALLOCATION_LIMIT_EXCEEDED, max)
+	}
+	s.allocations += n
+	if s.ctx.Err() != nil {
+		s.err = contextError(s.ctx)
+		return s.err
+	}
+	return nil
+}
+
+// 評価中の context。VM で実行している場合は context.Background()
+func (in *Interpreter) context() context.Context {
+	if in.current == nil {
+		return context.Background()
+	}
+	return in.current.ctx
+}
+
+// 組み込み関数が n 個の値を生成する前に、評価中の実行制限を確認する
+// VM で実行している場合は制限しない
+func (in *Interpreter) allocate(n int) *object.Error {
+	if in.current == nil {
+		return nil
+	}
+	return in.current.reserve(n)
+}
+
+// 関数を呼び出す前に呼ばれる。呼び出しが終わったら leave を呼ぶ
+func (s *state) enter() *object.Error {
+	if s.err != nil {
+		return s.err
+	}
+
+	s.depth++
+	max := s.interp.MaxDepth()
+	if s.depth > max {
+		s.depth--
+		return s.fail(object.DEPTH_LIMIT_ERROR, DEPTH_LIMIT_EXCEEDED, max)
+	}
+	return nil
+}
+
+func (s *state) leave() {
+	s.depth--
+}
+
+// Go のスタックの見積もりの上限
+// eval の入れ子１段を 1、関数呼び出し１段を callStackCost と数え、およそ 500 バイトずつに当たる
+// 呼び出しの深さが上限以下でも、深く入れ子になった式や大きな WithMaxDepth でスタックが溢れないようにする
+// 上限は 125MB ほどで、64 ビット環境のデフォルトの 1GB（スタックは倍々に伸びるので実際には 512MB）や
+// -race でフレームが大きくなる場合にも十分な余裕がある
+const (
+	maxNesting    = 250000
+	callStackCost = 8
+)
+
+// eval に入るごとに呼ばれる。抜けるときに nesting を戻す
+func (s *state) nest() *object.Error {
+	s.nesting++
+	if s.nesting+s.depth*callStackCost > maxNesting {
+		s.nesting--
+		return s.fail(object.DEPTH_LIMIT_ERROR, NESTING_LIMIT_EXCEEDED, maxNesting)
+	}
+	return nil
+}
+
+// 評価結果のオブジェクトを記録して返す
+// エラーや真偽値・null のように新しく生成されない値は数えない
+func (s *state) track(obj object.Object) object.Object {
+	switch obj.(type) {
+	case nil, *object.Error, *object.Boolean, *object.Null:
+		return obj
+	}
+	if err := s.allocate(1); err != nil {
+		return err
+	}
+	return obj
+}
+
+func (s *state) fail(kind object.ErrorKind, format string, a ...interface{}) *object.Error {
+	s.err = newError(format, a...)
+	s.err.Kind = kind
+	return s.err
+}
@@ -0,0 +1,241 @@
+package evaluator
+
+import (
+	"github.com/oteto/gonkey/pkg/ast"
+	"github.com/oteto/gonkey/pkg/object"
+)
+
+// 関数ごとの変数のスコープ
+// if などのブロックは新しいスコープを作らない
+type scope struct {
+	outer *scope
+
+	slots   map[string]int  // 関数内の let 文と引数で定義される変数（定義より前の位置にも巻き上げる）
+	defined map[string]bool // 走査している位置までに定義された変数
+}
+
+func newScope(outer *scope) *scope {
+	return &scope{
+		outer:   outer,
+		slots:   make(map[string]int),
+		defined: make(map[string]bool),
+	}
+}
+
+func (s *scope) declare(name string) {
+	if _, ok := s.slots[name]; !ok {
+		s.slots[name] = len(s.slots)
+	}
+}
+
+func (s *scope) isGlobal() bool {
+	return s.outer == nil
+}
+
+// 各 ast.Identifer に変数の位置を設定する
+//
+// 関数のローカル変数は (Depth, Slot) で、グローバル変数と組み込み関数は名前で参照する
+// 同じ関数内では定義より後の参照だけが有効で、内側の関数からは後で定義される変数も参照できる
+// どこにも定義されていない変数と、読み取り専用の変数の再定義はプログラムの実行前にエラーにする
+type resolver struct {
+	env    *object.Environment
+	interp *Interpreter // 組み込み関数と組み込みの値
+	scope  *scope
+	err    *object.Error
+
+	// 未定義の変数をエラーにしない
+	lenient bool
+	// 解決済みの AST を書き換えずに、未定義の変数などの検査だけをする
+	verify bool
+}
+
+// 解決済みの program は検査だけをする
+func resolve(program *ast.Program, env *object.Environment, interp *Interpreter) *object.Error {
+	r := &resolver{env: env, interp: interp, scope: newScope(nil), verify: program.Resolved}
+	r.declareLets(program)
+	r.resolve(program)
+	if r.err == nil && !r.verify {
+		program.Resolved = true
+	}
+	return r.err
+}
+
+// 変数の位置だけを解決する。未定義の変数はエラーにせず、実行時に名前で探す
+//
+// 解決済みの ast.Program は評価時に書き換えないので、複数の goroutine から同時に評価できる
+func Resolve(program *ast.Program) {
+	r := &resolver{scope: newScope(nil), lenient: true}
+	r.declareLets(program)
+	r.resolve(program)
+	program.Resolved = true
+}
+
+func (r *resolver) resolve(node ast.Node) {
+	ast.Inspect(node, r.visit)
+}
+
+func (r *resolver) visit(node ast.Node) bool {
+	if r.err != nil {
+		return false
+	}
+
+	switch node := node.(type) {
+	case *ast.LetStatement:
+		// 右辺では左辺の変数はまだ定義されていない
+		if node.Value != nil {
+			r.resolve(node.Value)
+		}
+		r.define(node.Name)
+		return false
+	case *ast.FunctionLiteral:
+		r.resolveFunction(node)
+		return false
+	case *ast.PropertyExpression:
+		// プロパティ名は変数ではない
+		r.resolve(node.Left)
+		return false
+	case *ast.Identifer:
+		r.resolveIdentifier(node)
+	}
+	return true
+}
+
+func (r *resolver) resolveFunction(fl *ast.FunctionLiteral) {
+	r.scope = newScope(r.scope)
+	defer func() { r.scope = r.scope.outer }()
+
+	for _, param := range fl.Patameters {
+		r.define(param)
+	}
+	r.declareLets(fl.Body)
+	r.resolve(fl.Body)
+	if r.verify {
+		return
+	}
+
+	markTailCalls(fl.Body)
+	fl.NumLocals = len(r.scope.slots)
+}
+
+// 関数本体の末尾位置にある呼び出しに印をつける
+// 末尾位置は、最後の式文と return 文の値、およびそれが if 式の場合の各ブロックの末尾
+func markTailCalls(body *ast.BlockStatement) {
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FunctionLiteral:
+			return false
+		case *ast.ReturnStatement:
+			markTailExpression(n.ReturnValue)
+		}
+		return true
+	})
+	markTailBlock(body)
+}
+
+func markTailBlock(block *ast.BlockStatement) {
+	if block == nil || len(block.Statements) == 0 {
+		return
+	}
+	if stmt, ok := block.Statements[len(block.Statements)-1].(*ast.ExpressionStatement); ok {
+		markTailExpression(stmt.Expression)
+	}
+}
+
+func markTailExpression(exp ast.Expression) {
+	switch exp := exp.(type) {
+	case *ast.CallExpression:
+		exp.Tail = true
+	case *ast.IfExpression:
+		markTailBlock(exp.Consequence)
+		markTailBlock(exp.Alternative)
+	case *ast.InfixExpression:
+		if exp.Operator == "|>" {
+			if call, ok := exp.Right.(*ast.CallExpression); ok {
+				call.Tail = true
+			}
+		}
+	}
+}
+
+// node の中の let 文で定義される変数を現在のスコープに宣言する
+// 内側の関数の let 文は含めない
+func (r *resolver) declareLets(node ast.Node) {
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FunctionLiteral:
+			return false
+		case *ast.LetStatement:
+			if n.Name != nil {
+				r.scope.declare(n.Name.Value)
+			}
+		}
+		return true
+	})
+}
+
+func (r *resolver) define(ident *ast.Identifer) {
+	if ident == nil {
+		return
+	}
+	// 読み取り専用の変数は関数の引数やローカル変数でも隠せない
+	if !r.lenient && r.env.IsReadOnly(ident.Value) {
+		r.err = newError(object.READ_ONLY_ERROR_PREFIX + ident.Value)
+		return
+	}
+	r.scope.declare(ident.Value)
+	r.scope.defined[ident.Value] = true
+
+	if r.scope.isGlobal() {
+		r.setLocation(ident, false, 0, 0)
+		return
+	}
+	r.setLocation(ident, true, 0, r.scope.slots[ident.Value])
+}
+
+func (r *resolver) resolveIdentifier(ident *ast.Identifer) {
+	name := ident.Value
+
+	depth := 0
+	for s := r.scope; s != nil; s = s.outer {
+		// 同じ関数内では定義済みの変数だけ、外側の関数では巻き上げた変数も参照できる
+		visible := s.defined[name]
+		if depth > 0 {
+			_, visible = s.slots[name]
+		}
+
+		if visible {
+			if s.isGlobal() {
+				r.setLocation(ident, false, 0, 0)
+				return
+			}
+			r.setLocation(ident, true, depth, s.slots[name])
+			return
+		}
+		depth++
+	}
+
+	// 以前の実行で定義されたグローバル変数か、組み込み関数・組み込みの値
+	r.setLocation(ident, false, 0, 0)
+	if r.lenient {
+		return
+	}
+	if _, ok := r.env.Get(name); ok {
+		return
+	}
+	if _, ok := r.interp.builtins[name]; ok {
+		return
+	}
+	if _, ok := r.interp.values[name]; ok {
+		return
+	}
+	r.err = newError(IDENTIFIER_NOT_FOUND_ERROR_PREFIX + name)
+}
+
+func (r *resolver) setLocation(ident *ast.Identifer, local bool, depth, slot int) {
+	if r.verify {
+		return
+	}
+	ident.Local = local
+	ident.Depth = depth
+	ident.Slot = slot
+}
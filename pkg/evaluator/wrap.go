@@ -0,0 +1,150 @@
+package evaluator
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/oteto/gonkey/pkg/object"
+)
+
+const (
+	BUILTIN_ARGUMENT_TYPE_MISMATCH    = "argument %d to `%s` must be %s, got %s"
+	BUILTIN_ARGUMENT_CONVERSION_ERROR = "argument %d to `%s`: %s"
+	BUILTIN_PANIC                     = "`%s` panicked: %v"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// Go の関数を組み込み関数に変換する
+//
+// 引数と戻り値には object.FromGo と object.ToGo で変換できる型と object.Object を使える
+// 戻り値は (), (T), (error), (T, error) のいずれかで、error を返すとスクリプトのエラーになる
+// 引数の数や型が合わない呼び出しと、fn が panic した呼び出しは、name を含むエラーメッセージのエラーになる
+func WrapFunc(name string, fn interface{}) (object.BuiltinFunction, error) {
+	v := reflect.ValueOf(fn)
+	if !v.IsValid() {
+		return nil, fmt.Errorf("%s: not a function: nil", name)
+	}
+	t := v.Type()
+	if t.Kind() != reflect.Func {
+		return nil, fmt.Errorf("%s: not a function: %s", name, t)
+	}
+	if v.IsNil() {
+		return nil, fmt.Errorf("%s: nil function: %s", name, t)
+	}
+
+	for i := 0; i < t.NumIn(); i++ {
+		in := t.In(i)
+		if t.IsVariadic() && i == t.NumIn()-1 {
+			in = in.Elem()
+		}
+		if !object.CanConvert(in) {
+			return nil, fmt.Errorf("%s: unsupported argument type: %s", name, in)
+		}
+	}
+
+	switch t.NumOut() {
+	case 0:
+	case 1:
+		if t.Out(0) != errorType && !object.CanConvert(t.Out(0)) {
+			return nil, fmt.Errorf("%s: unsupported return type: %s", name, t.Out(0))
+		}
+	case 2:
+		if !object.CanConvert(t.Out(0)) || t.Out(1) != errorType {
+			return nil, fmt.Errorf("%s: return types must be (T, error): %s", name, t)
+		}
+	default:
+		return nil, fmt.Errorf("%s: too many return values: %s", name, t)
+	}
+
+	return func(args ...object.Object) (result object.Object) {
+		in, err := convertArguments(name, t, args)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if r := recover(); r != nil {
+				result = newError(BUILTIN_PANIC, name, r)
+			}
+		}()
+		return convertResults(v.Call(in))
+	}, nil
+}
+
+// Go の関数を組み込み関数として登録する
+func (in *Interpreter) RegisterFunc(name string, fn interface{}) error {
+	builtin, err := WrapFunc(name, fn)
+	if err != nil {
+		return err
+	}
+	in.Register(name, builtin)
+	return nil
+}
+
+func convertArguments(name string, t reflect.Type, args []object.Object) ([]reflect.Value, *object.Error) {
+	numIn := t.NumIn()
+	if t.IsVariadic() {
+		if len(args) < numIn-1 {
+			return nil, newError(BUILTIN_NUMBER_OF_ARGUMENT_ERROR, len(args), numIn-1)
+		}
+	} else if len(args) != numIn {
+		return nil, newError(BUILTIN_NUMBER_OF_ARGUMENT_ERROR, len(args), numIn)
+	}
+
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var paramType reflect.Type
+		if t.IsVariadic() && i >= numIn-1 {
+			paramType = t.In(numIn - 1).Elem()
+		} else {
+			paramType = t.In(i)
+		}
+
+		value, err := object.ToGoValue(arg, paramType)
+		if err != nil {
+			if expected := expectedType(paramType); expected != "" && arg.Type() != expected {
+				return nil, newError(BUILTIN_ARGUMENT_TYPE_MISMATCH, i+1, name, expected, arg.Type())
+			}
+			return nil, newError(BUILTIN_ARGUMENT_CONVERSION_ERROR, i+1, name, err)
+		}
+		in[i] = value
+	}
+	return in, nil
+}
+
+// Go の t 型に変換できるスクリプトの値の型
+// 型が一つに決まらない場合は空文字列を返す
+func expectedType(t reflect.Type) object.ObjectType {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return object.INTEGER_OBJECT
+	case reflect.String:
+		return object.STRING_OBJECT
+	case reflect.Bool:
+		return object.BOOLEAN_OBJECT
+	case reflect.Slice, reflect.Array:
+		return object.ARRAY_OBJ
+	case reflect.Map, reflect.Struct:
+		return object.HASH_OBJ
+	}
+	return ""
+}
+
+func convertResults(out []reflect.Value) object.Object {
+	if len(out) > 0 && out[len(out)-1].Type() == errorType {
+		if err, _ := out[len(out)-1].Interface().(error); err != nil {
+			return newError("%s", err.Error())
+		}
+		out = out[:len(out)-1]
+	}
+	if len(out) == 0 {
+		return NULL
+	}
+
+	result, err := object.FromGo(out[0].Interface())
+	if err != nil {
+		return newError("%s", err.Error())
+	}
+	return result
+}
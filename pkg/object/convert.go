@@ -0,0 +1,437 @@
+package object
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+)
+
+const (
+	CONVERSION_TYPE_MISMATCH   = "cannot convert %s to %s"
+	CONVERSION_OUT_OF_RANGE    = "%d out of range for %s"
+	CONVERSION_UNSUPPORTED     = "unsupported type: %s"
+	CONVERSION_UNSUPPORTED_KEY = "unsupported hash key: %s"
+	CONVERSION_CYCLE           = "cyclic value of type %s"
+)
+
+// 構造体のフィールドに付けるタグ
+// `gonkey:"name"` でハッシュのキーを指定し、`gonkey:"-"` で変換から除外する
+const STRUCT_TAG = "gonkey"
+
+var (
+	objectType    = reflect.TypeOf((*Object)(nil)).Elem()
+	interfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+)
+
+// Go の値をスクリプトの値に変換する
+//
+// 整数型は Integer、string は String、bool は Boolean、スライスと配列は Array、
+// キーが文字列・整数・真偽値の map と構造体は Hash、nil は NULL になる
+// Object はそのまま返す
+// 自分自身を参照するポインタ・map・スライスはエラーにする
+func FromGo(v interface{}) (Object, error) {
+	return fromValue(reflect.ValueOf(v), map[visit]bool{})
+}
+
+// スクリプトの値を Go の値に変換する
+//
+// Integer は int64、String は string、Boolean は bool、Array は []interface{}、
+// Hash は map[string]interface{}、NULL は nil になる
+func ToGo(obj Object) (interface{}, error) {
+	v, err := ToGoValue(obj, interfaceType)
+	if err != nil {
+		return nil, err
+	}
+	return v.Interface(), nil
+}
+
+// スクリプトの値を Go の t 型の値に変換する
+func ToGoValue(obj Object, t reflect.Type) (reflect.Value, error) {
+	value := reflect.New(t).Elem()
+	if err := assign(value, obj); err != nil {
+		return value, err
+	}
+	return value, nil
+}
+
+// t 型の値を FromGo と ToGoValue で変換できるか
+func CanConvert(t reflect.Type) bool {
+	return canConvert(t, map[reflect.Type]bool{})
+}
+
+// seen は再帰的な型を一度だけ調べるために使う
+func canConvert(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if t == objectType || seen[t] {
+		return true
+	}
+	seen[t] = true
+
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.String, reflect.Bool:
+		return true
+	case reflect.Interface:
+		return t.NumMethod() == 0
+	case reflect.Slice, reflect.Array, reflect.Ptr:
+		return canConvert(t.Elem(), seen)
+	case reflect.Map:
+		return isHashKeyKind(t.Key().Kind()) && canConvert(t.Elem(), seen)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if _, ok := fieldName(field); ok && !canConvert(field.Type, seen) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// 変換中のポインタ・map・スライスが指す先
+// スライスは同じ配列の別の範囲を区別するため長さも含める
+type visit struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
+// v を変換中として記録する。変換中の値に戻ってきた場合は循環しているのでエラーにする
+// 同じ値を複数の場所から参照するだけなら循環ではないので、変換し終わったら呼び出し元で記録を消す
+func enterValue(v reflect.Value, visiting map[visit]bool) (visit, error) {
+	key := visit{ptr: v.Pointer(), typ: v.Type()}
+	if v.Kind() == reflect.Slice {
+		key.len = v.Len()
+	}
+	if visiting[key] {
+		return key, fmt.Errorf(CONVERSION_CYCLE, v.Type())
+	}
+	visiting[key] = true
+	return key, nil
+}
+
+// visiting は変換中の値（enterValue を参照）
+func fromValue(v reflect.Value, visiting map[visit]bool) (Object, error) {
+	if !v.IsValid() {
+		return NULL, nil
+	}
+	if obj, ok := v.Interface().(Object); ok {
+		if obj == nil || (v.Kind() == reflect.Ptr && v.IsNil()) {
+			return NULL, nil
+		}
+		return obj, nil
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Integer{Value: v.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf(CONVERSION_OUT_OF_RANGE, v.Uint(), INTEGER_OBJECT)
+		}
+		return &Integer{Value: int64(v.Uint())}, nil
+	case reflect.String:
+		return &String{Value: v.String()}, nil
+	case reflect.Bool:
+		if v.Bool() {
+			return TRUE, nil
+		}
+		return FALSE, nil
+	case reflect.Interface:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		return fromValue(v.Elem(), visiting)
+	case reflect.Ptr:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		ref, err := enterValue(v, visiting)
+		if err != nil {
+			return nil, err
+		}
+		defer delete(visiting, ref)
+		return fromValue(v.Elem(), visiting)
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice {
+			if v.IsNil() {
+				return NULL, nil
+			}
+			ref, err := enterValue(v, visiting)
+			if err != nil {
+				return nil, err
+			}
+			defer delete(visiting, ref)
+		}
+		elements := make([]Object, v.Len())
+		for i := range elements {
+			element, err := fromValue(v.Index(i), visiting)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			elements[i] = element
+		}
+		return &Array{Elements: elements}, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		ref, err := enterValue(v, visiting)
+		if err != nil {
+			return nil, err
+		}
+		defer delete(visiting, ref)
+		hash := NewHash(v.Len())
+		// Go の map は順序を持たないので、キーの順に並べる
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return lessMapKey(keys[i], keys[j]) })
+		for _, k := range keys {
+			key, err := fromValue(k, visiting)
+			if err != nil {
+				return nil, err
+			}
+			hashable, ok := key.(Hashable)
+			if !ok {
+				return nil, fmt.Errorf(CONVERSION_UNSUPPORTED_KEY, k.Type())
+			}
+			value, err := fromValue(v.MapIndex(k), visiting)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key.Inspect(), err)
+			}
+			hash.Set(hashable.HashKey(), HashPair{Key: key, Value: value})
+		}
+		return hash, nil
+	case reflect.Struct:
+		t := v.Type()
+		hash := NewHash(t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			name, ok := fieldName(t.Field(i))
+			if !ok {
+				continue
+			}
+			value, err := fromValue(v.Field(i), visiting)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			key := &String{Value: name}
+			hash.Set(key.HashKey(), HashPair{Key: key, Value: value})
+		}
+		return hash, nil
+	}
+	return nil, fmt.Errorf(CONVERSION_UNSUPPORTED, v.Type())
+}
+
+// スクリプトの値を変換して v に代入する
+func assign(v reflect.Value, obj Object) error {
+	t := v.Type()
+	if t == objectType {
+		v.Set(reflect.ValueOf(&obj).Elem())
+		return nil
+	}
+
+	if _, ok := obj.(*Null); ok {
+		switch t.Kind() {
+		case reflect.Interface, reflect.Ptr, reflect.Slice, reflect.Map:
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+		return fmt.Errorf(CONVERSION_TYPE_MISMATCH, obj.Type(), t)
+	}
+
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		integer, ok := obj.(*Integer)
+		if !ok {
+			return fmt.Errorf(CONVERSION_TYPE_MISMATCH, obj.Type(), t)
+		}
+		if v.OverflowInt(integer.Value) {
+			return fmt.Errorf(CONVERSION_OUT_OF_RANGE, integer.Value, t)
+		}
+		v.SetInt(integer.Value)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		integer, ok := obj.(*Integer)
+		if !ok {
+			return fmt.Errorf(CONVERSION_TYPE_MISMATCH, obj.Type(), t)
+		}
+		if integer.Value < 0 || v.OverflowUint(uint64(integer.Value)) {
+			return fmt.Errorf(CONVERSION_OUT_OF_RANGE, integer.Value, t)
+		}
+		v.SetUint(uint64(integer.Value))
+	case reflect.String:
+		str, ok := obj.(*String)
+		if !ok {
+			return fmt.Errorf(CONVERSION_TYPE_MISMATCH, obj.Type(), t)
+		}
+		v.SetString(str.Value)
+	case reflect.Bool:
+		boolean, ok := obj.(*Boolean)
+		if !ok {
+			return fmt.Errorf(CONVERSION_TYPE_MISMATCH, obj.Type(), t)
+		}
+		v.SetBool(boolean.Value)
+	case reflect.Interface:
+		if t.NumMethod() != 0 {
+			return fmt.Errorf(CONVERSION_UNSUPPORTED, t)
+		}
+		value, err := toInterface(obj)
+		if err != nil {
+			return err
+		}
+		if value != nil {
+			v.Set(reflect.ValueOf(value))
+		}
+	case reflect.Ptr:
+		elem := reflect.New(t.Elem())
+		if err := assign(elem.Elem(), obj); err != nil {
+			return err
+		}
+		v.Set(elem)
+	case reflect.Slice, reflect.Array:
+		array, ok := obj.(*Array)
+		if !ok {
+			return fmt.Errorf(CONVERSION_TYPE_MISMATCH, obj.Type(), t)
+		}
+		if t.Kind() == reflect.Slice {
+			v.Set(reflect.MakeSlice(t, len(array.Elements), len(array.Elements)))
+		} else if v.Len() != len(array.Elements) {
+			return fmt.Errorf(CONVERSION_TYPE_MISMATCH, obj.Inspect(), t)
+		}
+		for i, element := range array.Elements {
+			if err := assign(v.Index(i), element); err != nil {
+				return fmt.Errorf("[%d]: %w", i, err)
+			}
+		}
+	case reflect.Map:
+		hash, ok := obj.(*Hash)
+		if !ok {
+			return fmt.Errorf(CONVERSION_TYPE_MISMATCH, obj.Type(), t)
+		}
+		if !isHashKeyKind(t.Key().Kind()) {
+			return fmt.Errorf(CONVERSION_UNSUPPORTED, t)
+		}
+		v.Set(reflect.MakeMapWithSize(t, len(hash.Pairs)))
+		for _, pair := range hash.Pairs {
+			key := reflect.New(t.Key()).Elem()
+			if err := assign(key, pair.Key); err != nil {
+				return fmt.Errorf(CONVERSION_UNSUPPORTED_KEY, pair.Key.Inspect())
+			}
+			value := reflect.New(t.Elem()).Elem()
+			if err := assign(value, pair.Value); err != nil {
+				return fmt.Errorf("%s: %w", pair.Key.Inspect(), err)
+			}
+			v.SetMapIndex(key, value)
+		}
+	case reflect.Struct:
+		hash, ok := obj.(*Hash)
+		if !ok {
+			return fmt.Errorf(CONVERSION_TYPE_MISMATCH, obj.Type(), t)
+		}
+		// ハッシュにないフィールドはゼロ値のまま、フィールドにないキーは無視する
+		for i := 0; i < t.NumField(); i++ {
+			name, ok := fieldName(t.Field(i))
+			if !ok {
+				continue
+			}
+			key := &String{Value: name}
+			pair, ok := hash.Pairs[key.HashKey()]
+			if !ok {
+				continue
+			}
+			if err := assign(v.Field(i), pair.Value); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	default:
+		return fmt.Errorf(CONVERSION_UNSUPPORTED, t)
+	}
+	return nil
+}
+
+// 型を指定せずに Go の値に変換する
+func toInterface(obj Object) (interface{}, error) {
+	switch obj := obj.(type) {
+	case *Integer:
+		return obj.Value, nil
+	case *String:
+		return obj.Value, nil
+	case *Boolean:
+		return obj.Value, nil
+	case *Null:
+		return nil, nil
+	case *Array:
+		elements := make([]interface{}, len(obj.Elements))
+		for i, element := range obj.Elements {
+			value, err := toInterface(element)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			elements[i] = value
+		}
+		return elements, nil
+	case *Hash:
+		m := make(map[string]interface{}, len(obj.Pairs))
+		for _, pair := range obj.Pairs {
+			key, ok := pair.Key.(*String)
+			if !ok {
+				return nil, fmt.Errorf(CONVERSION_UNSUPPORTED_KEY, pair.Key.Inspect())
+			}
+			value, err := toInterface(pair.Value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key.Value, err)
+			}
+			m[key.Value] = value
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf(CONVERSION_UNSUPPORTED, obj.Type())
+}
+
+// 構造体のフィールドに対応するハッシュのキー
+// 非公開のフィールドと `gonkey:"-"` のフィールドは変換しない
+func fieldName(field reflect.StructField) (string, bool) {
+	if field.PkgPath != "" {
+		return "", false
+	}
+	tag := field.Tag.Get(STRUCT_TAG)
+	if tag == "-" {
+		return "", false
+	}
+	if tag != "" {
+		return tag, true
+	}
+	return field.Name, true
+}
+
+func lessMapKey(a, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() < b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return a.Uint() < b.Uint()
+	case reflect.String:
+		return a.String() < b.String()
+	case reflect.Bool:
+		return !a.Bool() && b.Bool()
+	}
+	// interface{} のキーは型ごとにまとめてから比べる
+	if a.Kind() == reflect.Interface && !a.IsNil() && !b.IsNil() {
+		a, b = a.Elem(), b.Elem()
+		if a.Kind() != b.Kind() {
+			return a.Kind() < b.Kind()
+		}
+		return lessMapKey(a, b)
+	}
+	return false
+}
+
+func isHashKeyKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.String, reflect.Bool:
+		return true
+	}
+	return false
+}
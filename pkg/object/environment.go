@@ -1,8 +1,14 @@
 package object
 
+const READ_ONLY_ERROR_PREFIX = "cannot assign to read-only variable: "
+
+// 変数の束縛
+// グローバル変数は名前で、関数のローカル変数は resolver が割り当てたスロットで管理する
 type Environment struct {
-	store map[string]Object
-	outer *Environment
+	store    map[string]Object
+	readOnly map[string]bool
+	slots    []Object
+	outer    *Environment
 }
 
 func NewEnvironment() *Environment {
@@ -10,23 +16,71 @@ func NewEnvironment() *Environment {
 	return &Environment{store: s}
 }
 
+// 関数呼び出しごとに size 個のスロットを持つ環境を作る
+func NewEnclosedEnvironment(outer *Environment, size int) *Environment {
+	return &Environment{slots: make([]Object, size), outer: outer}
+}
+
+// グローバル変数を名前で探す
 func (e *Environment) Get(name string) (Object, bool) {
-	obj, ok := e.store[name]
-	if !ok {
-		if e.outer != nil {
-			obj, ok = e.outer.Get(name)
-		}
-	}
+	obj, ok := e.global().store[name]
 	return obj, ok
 }
 
+// グローバル変数を設定する
+// 読み取り専用の変数は設定せずに Error を返す
 func (e *Environment) Set(name string, val Object) Object {
-	e.store[name] = val
+	global := e.global()
+	if global.readOnly[name] {
+		return &Error{Message: READ_ONLY_ERROR_PREFIX + name}
+	}
+	global.store[name] = val
 	return val
 }
 
-func NewEnclosedEnvironment(outer *Environment) *Environment {
-	env := NewEnvironment()
-	env.outer = outer
+// 読み取り専用のグローバル変数を設定する
+// ホストからスクリプトに値を渡すために使い、スクリプトからは再定義できない
+func (e *Environment) SetReadOnly(name string, val Object) {
+	global := e.global()
+	if global.readOnly == nil {
+		global.readOnly = make(map[string]bool)
+	}
+	global.store[name] = val
+	global.readOnly[name] = true
+}
+
+func (e *Environment) IsReadOnly(name string) bool {
+	return e.global().readOnly[name]
+}
+
+// depth 個外側の環境の slot 番目のローカル変数を返す
+// まだ代入されていない場合は nil を返す
+func (e *Environment) GetAt(depth, slot int) Object {
+	env := e
+	for i := 0; i < depth; i++ {
+		env = env.outer
+	}
+	if slot >= len(env.slots) {
+		return nil
+	}
+	return env.slots[slot]
+}
+
+// slot 番目のローカル変数を設定する
+func (e *Environment) SetAt(slot int, val Object) Object {
+	if slot >= len(e.slots) {
+		slots := make([]Object, slot+1)
+		copy(slots, e.slots)
+		e.slots = slots
+	}
+	e.slots[slot] = val
+	return val
+}
+
+func (e *Environment) global() *Environment {
+	env := e
+	for env.outer != nil {
+		env = env.outer
+	}
 	return env
 }
@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"fmt"
 	"hash/fnv"
+	"regexp"
 	"strings"
 
 	"github.com/oteto/gonkey/pkg/ast"
+	"github.com/oteto/gonkey/pkg/code"
 )
 
 const (
@@ -20,6 +22,10 @@ const (
 	BUILTIN_OBJ         = "BUILTIN"
 	ARRAY_OBJ           = "ARRAY"
 	HASH_OBJ            = "HASH"
+	REGEX_OBJ           = "REGEX"
+
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
+	CELL_OBJ              = "CELL"
 )
 
 type ObjectType string
@@ -55,6 +61,13 @@ func (b *Boolean) Type() ObjectType {
 
 type Null struct{}
 
+// 真偽値と null は同じ値を使い回す
+var (
+	TRUE  = &Boolean{Value: true}
+	FALSE = &Boolean{Value: false}
+	NULL  = &Null{}
+)
+
 func (n *Null) Inspect() string {
 	return "null"
 }
@@ -75,8 +88,21 @@ func (rv *ReturnValue) Type() ObjectType {
 	return RETURN_VALUE_OBJECT
 }
 
+// エラーの種類
+type ErrorKind string
+
+const (
+	RUNTIME_ERROR          ErrorKind = ""                 // 通常の実行時エラー
+	STEP_LIMIT_ERROR       ErrorKind = "STEP_LIMIT"       // 評価ステップ数の上限を超えた
+	ALLOCATION_LIMIT_ERROR ErrorKind = "ALLOCATION_LIMIT" // 生成したオブジェクト数の上限を超えた
+	TIMEOUT_ERROR          ErrorKind = "TIMEOUT"          // 実行時間の上限を超えた
+	CANCELED_ERROR         ErrorKind = "CANCELED"         // context がキャンセルされた
+	DEPTH_LIMIT_ERROR      ErrorKind = "DEPTH_LIMIT"      // 関数呼び出しの深さの上限を超えた
+)
+
 type Error struct {
 	Message string
+	Kind    ErrorKind
 }
 
 func (e *Error) Inspect() string {
@@ -91,6 +117,7 @@ type Function struct {
 	Parameters []*ast.Identifer
 	Body       *ast.BlockStatement
 	Env        *Environment
+	NumLocals  int // 呼び出し時に確保するローカル変数のスロット数
 }
 
 func (f *Function) Inspect() string {
@@ -129,8 +156,19 @@ func (s *String) Type() ObjectType {
 
 type BuiltinFunction func(args ...Object) Object
 
+// 組み込み関数からスクリプトの関数を呼び出す
+// tree-walker と VM がそれぞれの方法で呼び出す関数を渡す
+type CallFunction func(fn Object, args ...Object) Object
+
+// スクリプトの関数を引数に受け取って呼び出す組み込み関数
+type HigherOrderFunction func(call CallFunction, args ...Object) Object
+
 type Builtin struct {
 	Fn BuiltinFunction
+	// 設定されている場合は Fn の代わりに呼び出す
+	HigherOrderFn HigherOrderFunction
+	// 引数の評価で発生した実行時エラーを、中断せずに値として受け取る
+	AcceptsErrors bool
 }
 
 func (b *Builtin) Inspect() string {
@@ -200,6 +238,35 @@ type HashPair struct {
 
 type Hash struct {
 	Pairs map[HashKey]HashPair
+	Keys  []HashKey // 追加された順のキー
+}
+
+func NewHash(size int) *Hash {
+	return &Hash{Pairs: make(map[HashKey]HashPair, size), Keys: make([]HashKey, 0, size)}
+}
+
+// ペアを追加する。既にあるキーの場合は順番を変えずに値を置き換える
+func (h *Hash) Set(key HashKey, pair HashPair) {
+	if _, ok := h.Pairs[key]; !ok {
+		h.Keys = append(h.Keys, key)
+	}
+	h.Pairs[key] = pair
+}
+
+// ペアを追加された順で返す
+// Keys を持たない（Set を経由せずに作られた）場合は Pairs の順で返す
+func (h *Hash) OrderedPairs() []HashPair {
+	pairs := make([]HashPair, 0, len(h.Pairs))
+	if len(h.Keys) == len(h.Pairs) {
+		for _, key := range h.Keys {
+			pairs = append(pairs, h.Pairs[key])
+		}
+		return pairs
+	}
+	for _, pair := range h.Pairs {
+		pairs = append(pairs, pair)
+	}
+	return pairs
 }
 
 func (h *Hash) Type() ObjectType {
@@ -210,7 +277,7 @@ func (h *Hash) Inspect() string {
 	var out bytes.Buffer
 
 	pairs := []string{}
-	for _, pair := range h.Pairs {
+	for _, pair := range h.OrderedPairs() {
 		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
 	}
 
@@ -220,3 +287,67 @@ func (h *Hash) Inspect() string {
 
 	return out.String()
 }
+
+// コンパイル済みの正規表現
+type Regex struct {
+	Regexp *regexp.Regexp
+}
+
+func (r *Regex) Type() ObjectType {
+	return REGEX_OBJ
+}
+
+func (r *Regex) Inspect() string {
+	return "/" + r.Regexp.String() + "/"
+}
+
+// コンパイル済みの関数（定数としてバイトコードに埋め込まれる）
+type CompiledFunction struct {
+	Instructions  code.Instructions
+	NumLocals     int
+	NumParameters int
+	LocalNames    []string // ローカル変数名（インデックス順、エラーメッセージ用）
+	FreeNames     []string // 自由変数名（インデックス順、エラーメッセージ用）
+	Source        string   // Inspect 用の関数リテラル
+}
+
+func (cf *CompiledFunction) Type() ObjectType {
+	return COMPILED_FUNCTION_OBJ
+}
+
+func (cf *CompiledFunction) Inspect() string {
+	return cf.Source
+}
+
+// VM で実行される関数
+// 自由変数を Free に取り込む。後で代入されるローカル変数は Cell ごと取り込む
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []Object
+}
+
+// tree-walker の Function と同じ型として扱う
+func (c *Closure) Type() ObjectType {
+	return FUNCTION_OBJECT
+}
+
+func (c *Closure) Inspect() string {
+	return c.Fn.Inspect()
+}
+
+// クロージャに取り込まれる VM のローカル変数の入れ物
+// 取り込んだ後に代入された値も、tree-walker と同じく参照できるようにする
+type Cell struct {
+	Value Object // 未代入の場合は nil
+}
+
+func (c *Cell) Type() ObjectType {
+	return CELL_OBJ
+}
+
+func (c *Cell) Inspect() string {
+	if c.Value == nil {
+		return "cell()"
+	}
+	return "cell(" + c.Value.Inspect() + ")"
+}
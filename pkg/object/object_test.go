@@ -1,6 +1,9 @@
 package object
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -18,3 +21,188 @@ func TestStringHashKey(t *testing.T) {
 		t.Fatalf("strings with different content have same hash keys.")
 	}
 }
+
+type config struct {
+	Name    string `gonkey:"name"`
+	Port    int    `gonkey:"port"`
+	Debug   bool
+	Tags    []string `gonkey:"tags"`
+	Ignored string   `gonkey:"-"`
+	secret  string
+}
+
+func TestFromGo(t *testing.T) {
+	tests := []struct {
+		input  interface{}
+		expect string
+	}{
+		{nil, "null"},
+		{42, "42"},
+		{uint8(7), "7"},
+		{"gonkey", "gonkey"},
+		{true, "true"},
+		{[]int{1, 2, 3}, "[1, 2, 3]"},
+		{[2]string{"a", "b"}, "[a, b]"},
+		{[]interface{}{1, "a", nil}, "[1, a, null]"},
+		{map[string]interface{}{"a": []int{1}}, "{a: [1]}"},
+		{map[int]bool{1: true}, "{1: true}"},
+		{config{Name: "app", Ignored: "x", secret: "y"}, ""},
+		{&Integer{Value: 5}, "5"},
+		{(*config)(nil), "null"},
+	}
+
+	for _, tt := range tests {
+		obj, err := FromGo(tt.input)
+		if err != nil {
+			t.Fatalf("FromGo(%#v) error: %s", tt.input, err)
+		}
+		if tt.expect != "" && obj.Inspect() != tt.expect {
+			t.Errorf("FromGo(%#v) wrong. want=%s, got=%s", tt.input, tt.expect, obj.Inspect())
+		}
+	}
+
+	obj, err := FromGo(config{Name: "app", Port: 80, Ignored: "x", secret: "y"})
+	if err != nil {
+		t.Fatalf("FromGo(config) error: %s", err)
+	}
+	hash, ok := obj.(*Hash)
+	if !ok {
+		t.Fatalf("obj is not Hash. got=%T", obj)
+	}
+	if len(hash.Pairs) != 4 {
+		t.Errorf("hash has wrong number of pairs. got=%d", len(hash.Pairs))
+	}
+	for _, key := range []string{"name", "port", "Debug", "tags"} {
+		if _, ok := hash.Pairs[(&String{Value: key}).HashKey()]; !ok {
+			t.Errorf("hash has no key %q", key)
+		}
+	}
+}
+
+func TestFromGoError(t *testing.T) {
+	tests := []struct {
+		input  interface{}
+		expect string
+	}{
+		{1.5, "unsupported type: float64"},
+		{uint64(1 << 63), "9223372036854775808 out of range for INTEGER"},
+		{[]interface{}{1, make(chan int)}, "[1]: unsupported type: chan int"},
+		{map[string]interface{}{"f": func() {}}, "f: unsupported type: func()"},
+	}
+
+	for _, tt := range tests {
+		_, err := FromGo(tt.input)
+		if err == nil {
+			t.Fatalf("FromGo(%T) returned no error", tt.input)
+		}
+		if err.Error() != tt.expect {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expect, err.Error())
+		}
+	}
+}
+
+type node struct {
+	Value int
+	Next  *node
+}
+
+func TestFromGoCycle(t *testing.T) {
+	self := &node{Value: 1}
+	self.Next = self
+
+	loop := &node{Value: 1, Next: &node{Value: 2}}
+	loop.Next.Next = loop
+
+	m := map[string]interface{}{}
+	m["self"] = m
+
+	s := make([]interface{}, 1)
+	s[0] = s
+
+	tests := []struct {
+		input  interface{}
+		expect string
+	}{
+		{self, "Next: cyclic value of type *object.node"},
+		{loop, "Next: Next: cyclic value of type *object.node"},
+		{m, "self: cyclic value of type map[string]interface {}"},
+		{s, "[0]: cyclic value of type []interface {}"},
+	}
+
+	for _, tt := range tests {
+		_, err := FromGo(tt.input)
+		if err == nil {
+			t.Fatalf("FromGo(%T) returned no error for a cyclic value", tt.input)
+		}
+		if err.Error() != tt.expect {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expect, err.Error())
+		}
+	}
+
+	// 同じ値を複数の場所から参照するだけなら変換できる
+	shared := &node{Value: 1}
+	obj, err := FromGo([]*node{shared, shared})
+	if err != nil {
+		t.Fatalf("FromGo returned error: %s", err)
+	}
+	if obj.Inspect() != "[{Value: 1, Next: null}, {Value: 1, Next: null}]" {
+		t.Errorf("wrong value. got=%q", obj.Inspect())
+	}
+}
+
+func TestToGo(t *testing.T) {
+	hash := &Hash{Pairs: map[HashKey]HashPair{}}
+	for key, value := range map[string]Object{
+		"a": &Integer{Value: 1},
+		"b": &Array{Elements: []Object{&String{Value: "x"}, TRUE, NULL}},
+	} {
+		k := &String{Value: key}
+		hash.Pairs[k.HashKey()] = HashPair{Key: k, Value: value}
+	}
+
+	v, err := ToGo(hash)
+	if err != nil {
+		t.Fatalf("ToGo error: %s", err)
+	}
+	expect := map[string]interface{}{
+		"a": int64(1),
+		"b": []interface{}{"x", true, nil},
+	}
+	if !reflect.DeepEqual(v, expect) {
+		t.Errorf("ToGo wrong. want=%#v, got=%#v", expect, v)
+	}
+
+	intKey := &Integer{Value: 1}
+	_, err = ToGo(&Hash{Pairs: map[HashKey]HashPair{intKey.HashKey(): {Key: intKey, Value: NULL}}})
+	if err == nil || err.Error() != "unsupported hash key: 1" {
+		t.Errorf("wrong error for integer key. got=%v", err)
+	}
+
+	_, err = ToGo(&Function{})
+	if err == nil || err.Error() != "unsupported type: FUNCTION" {
+		t.Errorf("wrong error for function. got=%v", err)
+	}
+}
+
+func TestToGoValueRoundTrip(t *testing.T) {
+	original := config{Name: "app", Port: 8080, Debug: true, Tags: []string{"a", "b"}}
+
+	obj, err := FromGo(original)
+	if err != nil {
+		t.Fatalf("FromGo error: %s", err)
+	}
+	v, err := ToGoValue(obj, reflect.TypeOf(config{}))
+	if err != nil {
+		t.Fatalf("ToGoValue error: %s", err)
+	}
+	if !reflect.DeepEqual(v.Interface(), original) {
+		t.Errorf("round trip wrong. want=%#v, got=%#v", original, v.Interface())
+	}
+
+	port := &String{Value: "port"}
+	hash := &Hash{Pairs: map[HashKey]HashPair{port.HashKey(): {Key: port, Value: &String{Value: "80"}}}}
+	_, err = ToGoValue(hash, reflect.TypeOf(config{}))
+	if err == nil || err.Error() != "port: cannot convert STRING to int" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+}
@@ -0,0 +1,150 @@
+// 実行前に ast.Program を書き換えて、実行時の計算を減らす
+//
+// 畳み込みには evaluator と同じ演算を使うので、結果は実行時と変わらない
+// 実行時にエラーになる式はそのまま残し、エラーも実行時に同じように発生させる
+package optimizer
+
+import (
+	"strconv"
+
+	"github.com/oteto/gonkey/pkg/ast"
+	"github.com/oteto/gonkey/pkg/evaluator"
+	"github.com/oteto/gonkey/pkg/object"
+	"github.com/oteto/gonkey/pkg/token"
+)
+
+// 定数の前置・中置演算式を畳み込み、条件が定数の if 式から実行されない分岐を取り除く
+func Optimize(program *ast.Program) *ast.Program {
+	return ast.Rewrite(program, optimize).(*ast.Program)
+}
+
+func optimize(node ast.Node) ast.Node {
+	switch node := node.(type) {
+	case *ast.PrefixExpression:
+		return foldPrefixExpression(node)
+	case *ast.InfixExpression:
+		return foldInfixExpression(node)
+	case *ast.IfExpression:
+		return eliminateDeadBranch(node)
+	}
+	return node
+}
+
+func foldPrefixExpression(node *ast.PrefixExpression) ast.Node {
+	right, ok := constantValue(node.Right)
+	if !ok {
+		return node
+	}
+	return toLiteral(node, evaluator.ApplyPrefixOperator(node.Operator, right))
+}
+
+func foldInfixExpression(node *ast.InfixExpression) ast.Node {
+	if node.Operator == "|>" {
+		return node
+	}
+	left, ok := constantValue(node.Left)
+	if !ok {
+		return node
+	}
+	right, ok := constantValue(node.Right)
+	if !ok {
+		return node
+	}
+	// ゼロ除算は実行時に発生させる
+	if integer, ok := right.(*object.Integer); ok && node.Operator == "/" && integer.Value == 0 {
+		return node
+	}
+	return toLiteral(node, evaluator.ApplyInfixOperator(node.Operator, left, right))
+}
+
+// 条件が定数の場合、実行される分岐だけを条件 true の if 式として残す
+// どちらの分岐も実行されない場合は、値が null になるように空の if 式を残す
+func eliminateDeadBranch(node *ast.IfExpression) ast.Node {
+	condition, ok := constantValue(node.Condition)
+	if !ok {
+		return node
+	}
+
+	dead := node.Consequence
+	if evaluator.IsTruthy(condition) {
+		dead = node.Alternative
+	}
+	if declaresLet(dead) {
+		return node
+	}
+
+	if evaluator.IsTruthy(condition) {
+		node.Alternative = nil
+		node.Condition = booleanLiteral(true)
+		return node
+	}
+
+	if node.Alternative == nil {
+		node.Consequence = &ast.BlockStatement{Token: node.Consequence.Token}
+		node.Condition = booleanLiteral(false)
+		return node
+	}
+
+	node.Consequence = node.Alternative
+	node.Alternative = nil
+	node.Condition = booleanLiteral(true)
+	return node
+}
+
+// ブロックが let 文を含むか（内側の関数の中は除く）
+// let は関数の先頭に巻き上げられ、実行されない分岐にあっても外側の同じ名前の変数を隠すので、
+// そのような分岐を取り除くと変数の参照先が変わってしまう
+func declaresLet(block *ast.BlockStatement) bool {
+	if block == nil {
+		return false
+	}
+	found := false
+	ast.Inspect(block, func(n ast.Node) bool {
+		switch n.(type) {
+		case *ast.FunctionLiteral:
+			return false
+		case *ast.LetStatement:
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// 整数・文字列・真偽値のリテラルを値に変換する
+func constantValue(exp ast.Expression) (object.Object, bool) {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		return &object.Integer{Value: exp.Value}, true
+	case *ast.StringLiteral:
+		return &object.String{Value: exp.Value}, true
+	case *ast.Boolean:
+		if exp.Value {
+			return evaluator.TRUE, true
+		}
+		return evaluator.FALSE, true
+	}
+	return nil, false
+}
+
+// 畳み込んだ値をリテラルに変換する
+// エラーなどリテラルで表せない値の場合は元のノードを返す
+func toLiteral(original ast.Expression, value object.Object) ast.Node {
+	switch value := value.(type) {
+	case *object.Integer:
+		literal := strconv.FormatInt(value.Value, 10)
+		return &ast.IntegerLiteral{Token: token.Token{Type: token.INT, Literal: literal}, Value: value.Value}
+	case *object.String:
+		return &ast.StringLiteral{Token: token.Token{Type: token.STRING, Literal: value.Value}, Value: value.Value}
+	case *object.Boolean:
+		return booleanLiteral(value.Value)
+	}
+	return original
+}
+
+func booleanLiteral(value bool) *ast.Boolean {
+	if value {
+		return &ast.Boolean{Token: token.Token{Type: token.TRUE, Literal: "true"}, Value: true}
+	}
+	return &ast.Boolean{Token: token.Token{Type: token.FALSE, Literal: "false"}, Value: false}
+}
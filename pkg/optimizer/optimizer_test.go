@@ -0,0 +1,81 @@
+package optimizer
+
+import (
+	"testing"
+
+	"github.com/oteto/gonkey/pkg/ast"
+	"github.com/oteto/gonkey/pkg/evaluator"
+	"github.com/oteto/gonkey/pkg/object"
+	"github.com/oteto/gonkey/pkg/parser"
+	"github.com/oteto/gonkey/pkg/tokenizer"
+)
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect string
+	}{
+		{"60 * 60 * 24", "86400"},
+		{"-5 + 10", "5"},
+		{"1 + x * 2", "(1 + (x * 2))"},
+		{"x + 1 + 2", "((x + 1) + 2)"},
+		{`"foo" + "bar"`, "foobar"},
+		{"1 < 2", "true"},
+		{"!true == false", "true"},
+		{"!0", "false"},
+		{"10 / 0", "(10 / 0)"},
+		{"5 + true", "(5 + true)"},
+		{"-true", "(-true)"},
+		{`"a" - "b"`, "(a - b)"},
+		{"1 |> f", "(1 |> f)"},
+		{"if (true) { 1 } else { 2 }", "iftrue 1"},
+		{"if (1 > 2) { 1 } else { 2 }", "iftrue 2"},
+		{"if (false) { 1 }", "iffalse "},
+		{"if (x) { 1 + 1 } else { 2 * 2 }", "ifx 2else 4"},
+		{"fn(x) { if (true) { return 60 * 60; } }", "fn(x)iftrue return 3600;"},
+		{"let a = [1 + 1, {2 * 3: 4}][0];", "let a = ([2, {6:4}][0]);"},
+		{"if (false) { let a = 1 + 1; }", "iffalse let a = 2;"},
+		{"if (true) { 1 } else { let a = 1; }", "iftrue 1else let a = 1;"},
+		{"if (false) { fn() { let a = 1; } }", "iffalse "},
+	}
+
+	for _, tt := range tests {
+		program := Optimize(parse(tt.input))
+		if program.String() != tt.expect {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.expect, program.String())
+		}
+	}
+}
+
+// 最適化の前後で評価結果が変わらないことを確認する
+func TestOptimizeKeepsResult(t *testing.T) {
+	tests := []string{
+		"60 * 60 * 24",
+		"5 + true",
+		"-true",
+		"if (false) { 1 }",
+		"if (1 < 2) { 10 } else { 20 }",
+		"if (!true) { 10 } else { 2 * 3 }",
+		"let f = fn(x) { if (true) { x * (2 + 3) } }; f(2)",
+		`"a" + "b" == "ab"`,
+		"!(1 == 1)",
+		"-(-9223372036854775807 - 1)",
+		"let z = 5; let f = fn() { if (false) { let z = 1; } z }; f()",
+		"let z = 5; let f = fn() { if (true) { z } else { let z = 1; } }; f()",
+		"let z = 5; let f = fn() { if (false) { fn() { let z = 1; } } z }; f()",
+	}
+
+	for _, input := range tests {
+		expected := evaluator.Eval(parse(input), object.NewEnvironment())
+		actual := evaluator.Eval(Optimize(parse(input)), object.NewEnvironment())
+
+		if actual.Inspect() != expected.Inspect() {
+			t.Errorf("%s: result wrong. want=%s, got=%s", input, expected.Inspect(), actual.Inspect())
+		}
+	}
+}
+
+func parse(input string) *ast.Program {
+	p := parser.New(tokenizer.New(input))
+	return p.ParseProgram()
+}
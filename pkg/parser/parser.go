@@ -2,6 +2,7 @@ package parser
 
 import (
 	"fmt"
+	"io"
 	"strconv"
 
 	"github.com/oteto/gonkey/pkg/ast"
@@ -12,16 +13,18 @@ import (
 const (
 	_ int = iota
 	LOWEST
+	PIPE        // |>
 	EQUALS      // ==
 	LESSGREATER // > or <
 	SUM         // +
 	PRODUCT     // *
 	PREFIX      // -X or !X
 	CALL        // fn()
-	INDEX       // <array>[0]
+	INDEX       // <array>[0] or <hash>.key
 )
 
 var precedences = map[token.TokenType]int{
+	token.PIPE:     PIPE,
 	token.EQ:       EQUALS,
 	token.NOT_EQ:   EQUALS,
 	token.LT:       LESSGREATER,
@@ -32,6 +35,7 @@ var precedences = map[token.TokenType]int{
 	token.ASTER:    PRODUCT,
 	token.LPAREN:   CALL,
 	token.LBRACKET: INDEX,
+	token.DOT:      INDEX,
 }
 
 type (
@@ -48,13 +52,28 @@ type Parser struct {
 
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
+
+	tracer *tracer
+}
+
+// Parser の設定を変更する
+type Option func(*Parser)
+
+// 構文解析関数の呼び出しをインデント付きで w に書き出す
+func WithTrace(w io.Writer) Option {
+	return func(p *Parser) {
+		p.tracer = &tracer{out: w}
+	}
 }
 
-func New(t *tokenizer.Tokenizer) *Parser {
+func New(t *tokenizer.Tokenizer, opts ...Option) *Parser {
 	p := &Parser{
 		t:      t,
 		errors: []string{},
 	}
+	for _, opt := range opts {
+		opt(p)
+	}
 
 	// Token に対応する構文解析関数を登録する
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
@@ -80,8 +99,10 @@ func New(t *tokenizer.Tokenizer) *Parser {
 	p.registerInfix(token.GT, p.parseInfixExpression)
 	p.registerInfix(token.EQ, p.parseInfixExpression)
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.PIPE, p.parseInfixExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
+	p.registerInfix(token.DOT, p.parsePropertyExpression)
 
 	p.nextToken()
 	p.nextToken()
@@ -163,7 +184,7 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 }
 
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
-	defer untrace(trace("parseExpressionStatement"))
+	defer p.untrace(p.trace("parseExpressionStatement"))
 
 	stmt := &ast.ExpressionStatement{Token: p.currToken}
 	stmt.Expression = p.parseExpression(LOWEST)
@@ -176,7 +197,7 @@ func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 }
 
 func (p *Parser) parseExpression(precedence int) ast.Expression {
-	defer untrace(trace("parseExpression"))
+	defer p.untrace(p.trace("parseExpression"))
 
 	prefix := p.prefixParseFns[p.currToken.Type]
 
@@ -199,13 +220,13 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 }
 
 func (p *Parser) parseIdentifier() ast.Expression {
-	defer untrace(trace("parseIdentifier"))
+	defer p.untrace(p.trace("parseIdentifier"))
 
 	return &ast.Identifer{Token: p.currToken, Value: p.currToken.Literal}
 }
 
 func (p *Parser) parseIntegerLiteral() ast.Expression {
-	defer untrace(trace("parseIntegerLiteral"))
+	defer p.untrace(p.trace("parseIntegerLiteral"))
 
 	il := &ast.IntegerLiteral{Token: p.currToken}
 
@@ -219,7 +240,7 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 }
 
 func (p *Parser) parsePrefixExpression() ast.Expression {
-	defer untrace(trace("parsePrefixExpression"))
+	defer p.untrace(p.trace("parsePrefixExpression"))
 
 	exp := &ast.PrefixExpression{Token: p.currToken, Operator: p.currToken.Literal}
 	p.nextToken()
@@ -228,7 +249,7 @@ func (p *Parser) parsePrefixExpression() ast.Expression {
 }
 
 func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
-	defer untrace(trace("parseInfixExpression"))
+	defer p.untrace(p.trace("parseInfixExpression"))
 
 	exp := &ast.InfixExpression{
 		Token:    p.currToken,
@@ -243,7 +264,7 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 }
 
 func (p *Parser) parseBoolean() ast.Expression {
-	defer untrace(trace("parseBoolean"))
+	defer p.untrace(p.trace("parseBoolean"))
 
 	return &ast.Boolean{
 		Token: p.currToken,
@@ -404,15 +425,57 @@ func (p *Parser) parseExpressionList(endToken token.TokenType) []ast.Expression
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	exp := &ast.IndexExpression{Token: p.currToken, Left: left}
 	p.nextToken()
+
+	// a[:high]
+	if p.currTokenIs(token.COLON) {
+		return p.parseSliceExpression(exp.Token, left, nil)
+	}
+
 	exp.Index = p.parseExpression(LOWEST)
 
+	// a[low:] or a[low:high]
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		return p.parseSliceExpression(exp.Token, left, exp.Index)
+	}
+
+	if !p.peekTokenIs(token.RBRACKET) {
+		return nil
+	}
+	p.nextToken()
+	return exp
+}
+
+// : を読んだ状態でコールする
+func (p *Parser) parseSliceExpression(tkn token.Token, left, low ast.Expression) ast.Expression {
+	exp := &ast.SliceExpression{Token: tkn, Left: left, Low: low}
+
 	if !p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		exp.High = p.parseExpression(LOWEST)
+	}
+
+	if !p.peekTokenIs(token.RBRACKET) {
+		p.peekError(token.RBRACKET)
 		return nil
 	}
 	p.nextToken()
 	return exp
 }
 
+func (p *Parser) parsePropertyExpression(left ast.Expression) ast.Expression {
+	exp := &ast.PropertyExpression{Token: p.currToken, Left: left}
+
+	if !p.peekTokenIs(token.IDENT) {
+		p.peekError(token.IDENT)
+		return nil
+	}
+	p.nextToken()
+
+	exp.Property = &ast.Identifer{Token: p.currToken, Value: p.currToken.Literal}
+	return exp
+}
+
 func (p *Parser) parseHashLiteral() ast.Expression {
 	hash := &ast.HashLiteral{Token: p.currToken}
 	hash.Pairs = make(map[ast.Expression]ast.Expression)
@@ -429,6 +492,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		value := p.parseExpression(LOWEST)
 
 		hash.Pairs[key] = value
+		hash.Keys = append(hash.Keys, key)
 
 		if !p.peekTokenIs(token.RBRACE) {
 			if !p.peekTokenIs(token.COMMA) {
@@ -1,7 +1,10 @@
 package parser
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/oteto/gonkey/pkg/ast"
@@ -176,6 +179,7 @@ func TestParsingInfixExpression(t *testing.T) {
 		{"true == true;", true, "==", true},
 		{"true != false;", true, "!=", false},
 		{"false == false;", false, "==", false},
+		{"a |> b;", "a", "|>", "b"},
 	}
 
 	for _, tt := range tests {
@@ -305,6 +309,38 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a * b[2], b[1], 2 * [1, 2][1])",
 			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
 		},
+		{
+			"a.b.c",
+			"((a.b).c)",
+		},
+		{
+			"a |> f(b) |> g()",
+			"((a |> f(b)) |> g())",
+		},
+		{
+			"1 + 2 * 3 |> f()",
+			"((1 + (2 * 3)) |> f())",
+		},
+		{
+			"a == b |> f() != c",
+			"((a == b) |> (f() != c))",
+		},
+		{
+			"a |> b.c(d)",
+			"(a |> (b.c)(d))",
+		},
+		{
+			"-a.b * c",
+			"((-(a.b)) * c)",
+		},
+		{
+			"list.push(1 + 2).len()",
+			"((list.push)((1 + 2)).len)()",
+		},
+		{
+			"a.b[1] + c[2].d",
+			"(((a.b)[1]) + ((c[2]).d))",
+		},
 	}
 
 	for _, tt := range tests {
@@ -585,6 +621,130 @@ func TestParsingIndexExpression(t *testing.T) {
 	testInfixExpression(t, idx.Index, 1, "+", 1)
 }
 
+func TestParsingSliceExpression(t *testing.T) {
+	tests := []struct {
+		input  string
+		low    any
+		high   any
+		expect string
+	}{
+		{"a[1:3]", 1, 3, "(a[1:3])"},
+		{"a[:2]", nil, 2, "(a[:2])"},
+		{"a[1:]", 1, nil, "(a[1:])"},
+		{"a[:]", nil, nil, "(a[:])"},
+		{"a[-2:-1]", "(-2)", "(-1)", "(a[(-2):(-1)])"},
+		{"a[1 + 1:b]", "(1 + 1)", "b", "(a[(1 + 1):b])"},
+	}
+
+	for _, tt := range tests {
+		p := New(tokenizer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		checkStatementLength(t, program.Statements, 1)
+		stmt := testExpressoinStatement(t, program)
+		slice, ok := stmt.Expression.(*ast.SliceExpression)
+		if !ok {
+			t.Fatalf("stmt.Expression is not *ast.SliceExpression. got=%T", stmt.Expression)
+		}
+		testIdentifier(t, slice.Left, "a")
+		for _, bound := range []struct {
+			exp    ast.Expression
+			expect any
+		}{{slice.Low, tt.low}, {slice.High, tt.high}} {
+			switch expect := bound.expect.(type) {
+			case nil:
+				if bound.exp != nil {
+					t.Fatalf("slice bound is not nil. got=%s", bound.exp)
+				}
+			case int:
+				testIntegerLiteral(t, bound.exp, int64(expect))
+			case string:
+				if bound.exp == nil || bound.exp.String() != expect {
+					t.Fatalf("wrong slice bound. want=%q, got=%v", expect, bound.exp)
+				}
+			}
+		}
+		if program.String() != tt.expect {
+			t.Fatalf("wrong string. want=%q, got=%q", tt.expect, program.String())
+		}
+	}
+}
+
+func TestParsingSliceExpressionError(t *testing.T) {
+	p := New(tokenizer.New("a[1:2:3]"))
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) == 0 {
+		t.Fatalf("parser has no errors")
+	}
+	expected := "expected next token to be ], got : instead."
+	if errors[0] != expected {
+		t.Fatalf("wrong error. want=%q, got=%q", expected, errors[0])
+	}
+}
+
+func TestParsingPropertyExpression(t *testing.T) {
+	input := "person.name"
+	p := New(tokenizer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	checkStatementLength(t, program.Statements, 1)
+	stmt := testExpressoinStatement(t, program)
+	prop, ok := stmt.Expression.(*ast.PropertyExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not *ast.PropertyExpression. got=%T", stmt.Expression)
+	}
+	testIdentifier(t, prop.Left, "person")
+	testIdentifier(t, prop.Property, "name")
+
+	programJson, err := json.Marshal(program)
+	if err != nil {
+		t.Fatalf("json.Marshal failed. %v", err)
+	}
+	if !strings.Contains(string(programJson), `"Type":"PropertyExpressionNode"`) {
+		t.Fatalf("PropertyExpressionNode is not in JSON. got=%s", programJson)
+	}
+}
+
+func TestParsingMethodCallExpression(t *testing.T) {
+	input := "list.push(1, 2 * 3)"
+	p := New(tokenizer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	checkStatementLength(t, program.Statements, 1)
+	stmt := testExpressoinStatement(t, program)
+	call, ok := stmt.Expression.(*ast.CallExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not *ast.CallExpression. got=%T", stmt.Expression)
+	}
+	prop, ok := call.Function.(*ast.PropertyExpression)
+	if !ok {
+		t.Fatalf("call.Function is not *ast.PropertyExpression. got=%T", call.Function)
+	}
+	testIdentifier(t, prop.Left, "list")
+	testIdentifier(t, prop.Property, "push")
+	if len(call.Arguments) != 2 {
+		t.Fatalf("wrong args length. want=2, got=%d", len(call.Arguments))
+	}
+	testLiteralExpression(t, call.Arguments[0], 1)
+	testInfixExpression(t, call.Arguments[1], 2, "*", 3)
+}
+
+func TestParsingPropertyExpressionError(t *testing.T) {
+	p := New(tokenizer.New("person.1"))
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) == 0 {
+		t.Fatalf("parser has no errors")
+	}
+	expected := "expected next token to be IDENT, got INT instead."
+	if errors[0] != expected {
+		t.Fatalf("wrong error. want=%q, got=%q", expected, errors[0])
+	}
+}
+
 func TestParsingHashLiteralsStringKeys(t *testing.T) {
 	input := `{"one": 1, "two": 2, "three": 3}`
 	p := New(tokenizer.New(input))
@@ -667,6 +827,45 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 	}
 }
 
+func TestParserTrace(t *testing.T) {
+	var buf bytes.Buffer
+	p := New(tokenizer.New("-1 + 2"), WithTrace(&buf))
+	p.ParseProgram()
+	checkParserErrors(t, p)
+
+	expected := `BEGIN parseExpressionStatement
+    BEGIN parseExpression
+        BEGIN parsePrefixExpression
+            BEGIN parseExpression
+                BEGIN parseIntegerLiteral
+                END parseIntegerLiteral
+            END parseExpression
+        END parsePrefixExpression
+        BEGIN parseInfixExpression
+            BEGIN parseExpression
+                BEGIN parseIntegerLiteral
+                END parseIntegerLiteral
+            END parseExpression
+        END parseInfixExpression
+    END parseExpression
+END parseExpressionStatement
+`
+	if buf.String() != expected {
+		t.Fatalf("wrong trace output. want=\n%s\ngot=\n%s", expected, buf.String())
+	}
+}
+
+func TestParserTraceIsPerParser(t *testing.T) {
+	var buf bytes.Buffer
+	traced := New(tokenizer.New("1"), WithTrace(&buf))
+	New(tokenizer.New("1 + 2 + 3")).ParseProgram()
+	traced.ParseProgram()
+
+	if strings.Count(buf.String(), "BEGIN parseIntegerLiteral") != 1 {
+		t.Fatalf("trace output contains other parser's calls. got=\n%s", buf.String())
+	}
+}
+
 func testExpressoinStatement(t *testing.T, program *ast.Program) *ast.ExpressionStatement {
 	t.Helper()
 	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
@@ -2,32 +2,43 @@ package parser
 
 import (
 	"fmt"
+	"io"
 	"strings"
 )
 
-var traceLevel int = 0
-
 const traceIdentPlaceholder string = "    "
 
-func identLevel() string {
-	return strings.Repeat(traceIdentPlaceholder, traceLevel-1)
+// パーサの呼び出しをインデント付きで書き出す
+// Parser ごとに持つので、複数の Parser が同時に動いても出力は混ざらない
+type tracer struct {
+	out   io.Writer
+	level int
+}
+
+func (t *tracer) identLevel() string {
+	return strings.Repeat(traceIdentPlaceholder, t.level-1)
 }
 
-func tracePrint(fs string) {
-	// fmt.Printf("%s%s\n", identLevel(), fs)
-	fmt.Print("")
+func (t *tracer) tracePrint(fs string) {
+	fmt.Fprintf(t.out, "%s%s\n", t.identLevel(), fs)
 }
 
-func incIdent() { traceLevel = traceLevel + 1 }
-func decIdent() { traceLevel = traceLevel - 1 }
+func (t *tracer) incIdent() { t.level = t.level + 1 }
+func (t *tracer) decIdent() { t.level = t.level - 1 }
 
-func trace(msg string) string {
-	incIdent()
-	tracePrint("BEGIN " + msg)
+func (p *Parser) trace(msg string) string {
+	if p.tracer == nil {
+		return msg
+	}
+	p.tracer.incIdent()
+	p.tracer.tracePrint("BEGIN " + msg)
 	return msg
 }
 
-func untrace(msg string) {
-	tracePrint("END " + msg)
-	decIdent()
+func (p *Parser) untrace(msg string) {
+	if p.tracer == nil {
+		return
+	}
+	p.tracer.tracePrint("END " + msg)
+	p.tracer.decIdent()
 }
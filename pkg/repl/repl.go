@@ -8,10 +8,13 @@ import (
 	"io"
 	"log"
 
+	"github.com/oteto/gonkey/pkg/compiler"
 	"github.com/oteto/gonkey/pkg/evaluator"
 	"github.com/oteto/gonkey/pkg/object"
+	"github.com/oteto/gonkey/pkg/optimizer"
 	"github.com/oteto/gonkey/pkg/parser"
 	"github.com/oteto/gonkey/pkg/tokenizer"
+	"github.com/oteto/gonkey/pkg/vm"
 )
 
 const PROMPT = ">> "
@@ -42,7 +45,7 @@ func TokenizerStart(in io.Reader, out io.Writer) {
 	}
 }
 
-func ParserStart(in io.Reader, out io.Writer) {
+func ParserStart(in io.Reader, out io.Writer, opts ...parser.Option) {
 	scanner := bufio.NewScanner(in)
 
 	for {
@@ -53,7 +56,7 @@ func ParserStart(in io.Reader, out io.Writer) {
 		}
 
 		line := scanner.Text()
-		p := parser.New(tokenizer.New(line))
+		p := parser.New(tokenizer.New(line), opts...)
 		program := p.ParseProgram()
 		programJson, err := json.Marshal(program)
 		if err != nil {
@@ -77,7 +80,7 @@ func printParserError(out io.Writer, errors []string) {
 
 func EvalStart(in io.Reader, out io.Writer) {
 	scanner := bufio.NewScanner(in)
-	env := object.NewEnvironment()
+	interp := evaluator.New(evaluator.WithStdout(out))
 
 	for {
 		fmt.Print(PROMPT)
@@ -93,7 +96,47 @@ func EvalStart(in io.Reader, out io.Writer) {
 			continue
 		}
 
-		evaluated := evaluator.Eval(program, env)
+		evaluated := interp.Eval(optimizer.Optimize(program))
+		if evaluated != nil {
+			io.WriteString(out, evaluated.Inspect())
+			io.WriteString(out, "\n")
+		}
+	}
+}
+
+// EvalStart と同じ入出力で、バイトコードにコンパイルして VM で実行する
+func CompileStart(in io.Reader, out io.Writer) {
+	scanner := bufio.NewScanner(in)
+
+	interp := evaluator.New(evaluator.WithStdout(out))
+	constants := []object.Object{}
+	globals := make([]object.Object, vm.GlobalsSize)
+	symbolTable := compiler.NewSymbolTable()
+
+	for {
+		fmt.Print(PROMPT)
+		if !scanner.Scan() {
+			return
+		}
+
+		line := scanner.Text()
+		p := parser.New(tokenizer.New(line))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			printParserError(out, p.Errors())
+			continue
+		}
+
+		comp := compiler.NewWithState(symbolTable, constants, compiler.WithBuiltins(interp))
+		if err := comp.Compile(optimizer.Optimize(program)); err != nil {
+			fmt.Fprintf(out, "compilation failed:\n\t%s\n", err)
+			continue
+		}
+
+		bytecode := comp.Bytecode()
+		constants = bytecode.Constants
+
+		evaluated := vm.NewWithInterpreter(bytecode, globals, interp).Run()
 		if evaluated != nil {
 			io.WriteString(out, evaluated.Inspect())
 			io.WriteString(out, "\n")
@@ -20,11 +20,13 @@ const (
 	GT     = ">"
 	EQ     = "=="
 	NOT_EQ = "!="
+	PIPE   = "|>"
 
 	// デリミタ（セパレータ）
 	COMMA     = ","
 	SEMICOLON = ";"
 	COLON     = ":"
+	DOT       = "."
 
 	// 括弧
 	LPAREN   = "("
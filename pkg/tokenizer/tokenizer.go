@@ -52,6 +52,12 @@ func (t *Tokenizer) NextToken() token.Token {
 			break
 		}
 		tkn = token.NewToken(token.BANG, t.char)
+	case '|':
+		if t.peekChar() == '>' {
+			tkn = t.makeTwoCharToken(token.PIPE)
+			break
+		}
+		tkn = token.NewToken(token.ILLEGAL, t.char)
 	case '<':
 		tkn = token.NewToken(token.LT, t.char)
 	case '>':
@@ -80,6 +86,8 @@ func (t *Tokenizer) NextToken() token.Token {
 		tkn = token.NewToken(token.RBRACKET, t.char)
 	case ':':
 		tkn = token.NewToken(token.COLON, t.char)
+	case '.':
+		tkn = token.NewToken(token.DOT, t.char)
 	default:
 		if isLetter(t.char) {
 			tkn.Literal = t.readIdentifer()
@@ -30,6 +30,8 @@ if (5 < 10) {
 "foo bar"
 [1,"s"];
 {"foo":"bar"};
+list.push(1);
+xs |> f() | x;
 `
 
 	tests := []struct {
@@ -123,6 +125,21 @@ if (5 < 10) {
 		{token.STRING, "bar"},
 		{token.RBRACE, "}"},
 		{token.SEMICOLON, ";"},
+		{token.IDENT, "list"},
+		{token.DOT, "."},
+		{token.IDENT, "push"},
+		{token.LPAREN, "("},
+		{token.INT, "1"},
+		{token.RPAREN, ")"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "xs"},
+		{token.PIPE, "|>"},
+		{token.IDENT, "f"},
+		{token.LPAREN, "("},
+		{token.RPAREN, ")"},
+		{token.ILLEGAL, "|"},
+		{token.IDENT, "x"},
+		{token.SEMICOLON, ";"},
 		{token.EOF, ""},
 	}
 
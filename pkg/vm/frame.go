@@ -0,0 +1,21 @@
+package vm
+
+import (
+	"github.com/oteto/gonkey/pkg/code"
+	"github.com/oteto/gonkey/pkg/object"
+)
+
+// 関数呼び出しごとの実行状態
+type Frame struct {
+	cl          *object.Closure
+	ip          int // 実行中の命令の位置
+	basePointer int // ローカル変数の先頭のスタック位置
+}
+
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
+	return &Frame{cl: cl, ip: -1, basePointer: basePointer}
+}
+
+func (f *Frame) Instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
@@ -0,0 +1,669 @@
+// compiler.Bytecode を実行するスタックベースの仮想マシン
+//
+// 演算・インデックス・組み込み関数は evaluator と共有しているので、tree-walker と同じ結果になる
+// 関数呼び出しの深さの上限と末尾呼び出しの扱いも tree-walker と同じ
+package vm
+
+import (
+	"fmt"
+
+	"github.com/oteto/gonkey/pkg/code"
+	"github.com/oteto/gonkey/pkg/compiler"
+	"github.com/oteto/gonkey/pkg/evaluator"
+	"github.com/oteto/gonkey/pkg/object"
+)
+
+const (
+	InitialStackSize = 2048
+	MaxStackSize     = 1 << 20
+	GlobalsSize      = 65536
+
+	STACK_OVERFLOW_ERROR = "stack overflow"
+)
+
+var infixOperators = map[code.Opcode]string{
+	code.OpAdd:         "+",
+	code.OpSub:         "-",
+	code.OpMul:         "*",
+	code.OpDiv:         "/",
+	code.OpEqual:       "==",
+	code.OpNotEqual:    "!=",
+	code.OpGreaterThan: ">",
+	code.OpLessThan:    "<",
+}
+
+type VM struct {
+	interp *evaluator.Interpreter // 組み込み関数とその入出力先
+
+	constants []object.Object
+
+	globals     []object.Object
+	globalNames []string
+
+	stack []object.Object
+	sp    int // 次に積む位置。スタックの先頭は stack[sp-1]
+
+	frames   []*Frame
+	maxDepth int // 関数呼び出しの深さの上限（interp と同じ）
+
+	// OpCatch で登録した捕捉先。内側のものほど後ろにある
+	handlers []handler
+
+	lastPopped object.Object
+}
+
+// 引数で発生したエラーの捕捉先
+// 呼び出す関数がエラーを受け取る組み込み関数の場合だけ捕捉する
+type handler struct {
+	frames int    // 登録したときのフレーム数
+	sp     int    // 登録したときのスタック位置
+	callee int    // 呼び出す関数（メソッドの場合はレシーバ）のスタック位置。レシーバ自身を評価している場合は -1
+	method string // メソッド呼び出しの場合はメソッド名
+	target int    // 捕捉した後に続ける命令の位置
+}
+
+func New(bytecode *compiler.Bytecode) *VM {
+	return NewWithGlobalsStore(bytecode, make([]object.Object, GlobalsSize))
+}
+
+// REPL のように、前回の実行結果のグローバル変数を引き継いで実行する
+func NewWithGlobalsStore(bytecode *compiler.Bytecode, globals []object.Object) *VM {
+	return NewWithInterpreter(bytecode, globals, evaluator.New())
+}
+
+// interp の組み込み関数を使って実行する
+func NewWithInterpreter(bytecode *compiler.Bytecode, globals []object.Object, interp *evaluator.Interpreter) *VM {
+	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions}
+	mainClosure := &object.Closure{Fn: mainFn}
+	mainFrame := NewFrame(mainClosure, 0)
+
+	return &VM{
+		interp:      interp,
+		constants:   bytecode.Constants,
+		globals:     globals,
+		globalNames: bytecode.Globals,
+		stack:       make([]object.Object, InitialStackSize),
+		sp:          0,
+		frames:      []*Frame{mainFrame},
+		maxDepth:    interp.MaxDepth(),
+	}
+}
+
+// バイトコードを実行し、最後に評価した式の値を返す
+// 実行時エラーが発生した場合はその時点で止まり、*object.Error を返す
+func (vm *VM) Run() object.Object {
+	return vm.run(0)
+}
+
+// フレーム数が depth に戻るまで実行し、戻り値を返す
+// depth が 0 の場合はプログラムの終わりまで実行する
+func (vm *VM) run(depth int) object.Object {
+	var ip int
+	var ins code.Instructions
+	var op code.Opcode
+
+	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		vm.currentFrame().ip++
+
+		ip = vm.currentFrame().ip
+		ins = vm.currentFrame().Instructions()
+		op = code.Opcode(ins[ip])
+
+		var err *object.Error
+
+		switch op {
+		case code.OpConstant:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+			err = vm.push(vm.constants[constIndex])
+
+		case code.OpPop:
+			vm.lastPopped = vm.pop()
+
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv,
+			code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan:
+			right := vm.pop()
+			left := vm.pop()
+			err = vm.pushResult(evaluator.ApplyInfixOperator(infixOperators[op], left, right))
+
+		case code.OpBang:
+			err = vm.pushResult(evaluator.ApplyPrefixOperator("!", vm.pop()))
+
+		case code.OpMinus:
+			err = vm.pushResult(evaluator.ApplyPrefixOperator("-", vm.pop()))
+
+		case code.OpTrue:
+			err = vm.push(evaluator.TRUE)
+
+		case code.OpFalse:
+			err = vm.push(evaluator.FALSE)
+
+		case code.OpNull:
+			err = vm.push(evaluator.NULL)
+
+		case code.OpJump:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip = pos - 1
+
+		case code.OpJumpNotTruthy:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			condition := vm.pop()
+			if !evaluator.IsTruthy(condition) {
+				vm.currentFrame().ip = pos - 1
+			}
+
+		case code.OpSetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+			vm.globals[globalIndex] = vm.pop()
+			// tree-walker と同じく let 文の値は nil
+			vm.lastPopped = nil
+
+		case code.OpGetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+			err = vm.pushGlobal(int(globalIndex))
+
+		case code.OpSetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+			frame := vm.currentFrame()
+			vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
+
+		case code.OpGetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+			err = vm.pushLocal(int(localIndex))
+
+		case code.OpGetBuiltin:
+			nameIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+			err = vm.pushBuiltin(vm.constants[nameIndex].(*object.String).Value)
+
+		case code.OpGetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+			err = vm.push(vm.currentFrame().cl.Free[freeIndex])
+
+		case code.OpCurrentClosure:
+			err = vm.push(vm.currentFrame().cl)
+
+		case code.OpMakeCell:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+			slot := vm.currentFrame().basePointer + int(localIndex)
+			vm.stack[slot] = &object.Cell{Value: vm.stack[slot]}
+
+		case code.OpGetCell:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+			frame := vm.currentFrame()
+			cell := vm.stack[frame.basePointer+int(localIndex)].(*object.Cell)
+			err = vm.pushCell(cell, frame.cl.Fn.LocalNames[localIndex])
+
+		case code.OpSetCell:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+			frame := vm.currentFrame()
+			vm.stack[frame.basePointer+int(localIndex)].(*object.Cell).Value = vm.pop()
+
+		case code.OpGetFreeCell:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+			cl := vm.currentFrame().cl
+			err = vm.pushCell(cl.Free[freeIndex].(*object.Cell), cl.Fn.FreeNames[freeIndex])
+
+		case code.OpArray:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			array := vm.buildArray(vm.sp-numElements, vm.sp)
+			vm.sp = vm.sp - numElements
+			err = vm.push(array)
+
+		case code.OpHash:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			hash := vm.buildHash(vm.sp-numElements, vm.sp)
+			vm.sp = vm.sp - numElements
+			err = vm.pushResult(hash)
+
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+			err = vm.pushResult(evaluator.Index(left, index))
+
+		case code.OpSlice:
+			bounds := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			var low, high object.Object
+			if bounds&code.SliceHigh != 0 {
+				high = vm.pop()
+			}
+			if bounds&code.SliceLow != 0 {
+				low = vm.pop()
+			}
+			left := vm.pop()
+			err = vm.pushResult(evaluator.Slice(left, low, high))
+
+		case code.OpProperty:
+			nameIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+			left := vm.pop()
+			err = vm.pushResult(evaluator.Property(left, vm.constants[nameIndex].(*object.String).Value))
+
+		case code.OpCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+			err = vm.call(vm.stack[vm.sp-1-int(numArgs)], int(numArgs))
+
+		case code.OpTailCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+			err = vm.tailCall(int(numArgs))
+
+		case code.OpMethod:
+			nameIndex := code.ReadUint16(ins[ip+1:])
+			numArgs := int(code.ReadUint8(ins[ip+3:]))
+			vm.currentFrame().ip += 3
+			err = vm.callMethod(vm.constants[nameIndex].(*object.String).Value, numArgs)
+
+		case code.OpReturnValue:
+			returnValue := vm.pop()
+			// トップレベルの return はプログラムを終了する
+			if len(vm.frames) == 1 {
+				return returnValue
+			}
+
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+			vm.dropHandlers()
+			if len(vm.frames) == depth {
+				return returnValue
+			}
+			err = vm.push(returnValue)
+
+		case code.OpReturn:
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+			vm.dropHandlers()
+			if len(vm.frames) == depth {
+				return evaluator.NULL
+			}
+			err = vm.push(evaluator.NULL)
+
+		case code.OpClosure:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			numFree := code.ReadUint8(ins[ip+3:])
+			vm.currentFrame().ip += 3
+			err = vm.pushClosure(int(constIndex), int(numFree))
+
+		case code.OpCatch:
+			target := int(code.ReadUint16(ins[ip+1:]))
+			argIndex := int(code.ReadUint8(ins[ip+3:]))
+			vm.currentFrame().ip += 3
+			vm.pushHandler(vm.sp-1-argIndex, "", target)
+
+		case code.OpCatchMethod:
+			target := int(code.ReadUint16(ins[ip+1:]))
+			nameIndex := code.ReadUint16(ins[ip+3:])
+			argIndex := int(code.ReadUint8(ins[ip+5:]))
+			vm.currentFrame().ip += 5
+			vm.pushHandler(vm.sp-1-argIndex, vm.constants[nameIndex].(*object.String).Value, target)
+
+		case code.OpCatchReceiver:
+			target := int(code.ReadUint16(ins[ip+1:]))
+			nameIndex := code.ReadUint16(ins[ip+3:])
+			vm.currentFrame().ip += 4
+			vm.pushHandler(-1, vm.constants[nameIndex].(*object.String).Value, target)
+
+		case code.OpEndCatch:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+		default:
+			def, _ := code.Lookup(byte(op))
+			name := fmt.Sprintf("%d", op)
+			if def != nil {
+				name = def.Name
+			}
+			return newError("unknown opcode: %s", name)
+		}
+
+		if err != nil {
+			if !vm.catch(err, depth) {
+				return err
+			}
+		}
+	}
+
+	return vm.lastPopped
+}
+
+// err を受け取る組み込み関数の引数の中で発生した場合は、
+// 捕捉した位置まで戻って err を引数の値として積み、true を返す
+// depth より外側の run で登録された捕捉先は、その run に任せる
+func (vm *VM) catch(err *object.Error, depth int) bool {
+	if err.Kind != object.RUNTIME_ERROR {
+		return false
+	}
+	for len(vm.handlers) > 0 {
+		h := vm.handlers[len(vm.handlers)-1]
+		if h.frames <= depth {
+			return false
+		}
+		vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+		if !vm.acceptsErrors(h, err) {
+			continue
+		}
+		vm.frames = vm.frames[:h.frames]
+		vm.sp = h.sp
+		vm.currentFrame().ip = h.target - 1
+		return vm.push(err) == nil
+	}
+	return false
+}
+
+func (vm *VM) pushHandler(callee int, method string, target int) {
+	vm.handlers = append(vm.handlers, handler{
+		frames: len(vm.frames),
+		sp:     vm.sp,
+		callee: callee,
+		method: method,
+		target: target,
+	})
+}
+
+// h で捕捉した err を、呼び出す関数が引数として受け取るか
+func (vm *VM) acceptsErrors(h handler, err *object.Error) bool {
+	var function object.Object = err
+	if h.callee >= 0 {
+		function = vm.stack[h.callee]
+	}
+	if h.method != "" {
+		function, _ = vm.interp.LookupMethod(function, h.method)
+	}
+	builtin, ok := function.(*object.Builtin)
+	return ok && builtin.AcceptsErrors
+}
+
+// 戻った関数の中で登録された捕捉先を取り除く
+func (vm *VM) dropHandlers() {
+	for len(vm.handlers) > 0 && vm.handlers[len(vm.handlers)-1].frames > len(vm.frames) {
+		vm.handlers = vm.handlers[:len(vm.handlers)-1]
+	}
+}
+
+// 最後にスタックから取り除いた値
+func (vm *VM) LastPoppedStackElem() object.Object {
+	return vm.lastPopped
+}
+
+func (vm *VM) push(o object.Object) *object.Error {
+	if vm.sp >= len(vm.stack) {
+		if err := vm.growStack(vm.sp + 1); err != nil {
+			return err
+		}
+	}
+
+	vm.stack[vm.sp] = o
+	vm.sp++
+
+	return nil
+}
+
+// 演算の結果を積む。結果がエラーの場合は積まずに返す
+func (vm *VM) pushResult(o object.Object) *object.Error {
+	if err, ok := o.(*object.Error); ok {
+		return err
+	}
+	if o == nil {
+		o = evaluator.NULL
+	}
+	return vm.push(o)
+}
+
+func (vm *VM) pop() object.Object {
+	o := vm.stack[vm.sp-1]
+	vm.sp--
+	return o
+}
+
+// スタックの長さを size 以上にする
+func (vm *VM) growStack(size int) *object.Error {
+	if size > MaxStackSize {
+		return newError(STACK_OVERFLOW_ERROR)
+	}
+	newSize := len(vm.stack) * 2
+	for newSize < size {
+		newSize *= 2
+	}
+	if newSize > MaxStackSize {
+		newSize = MaxStackSize
+	}
+	stack := make([]object.Object, newSize)
+	copy(stack, vm.stack)
+	vm.stack = stack
+	return nil
+}
+
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[len(vm.frames)-1]
+}
+
+// 最初のフレームはプログラム本体なので、関数呼び出しの深さは len(vm.frames) - 1
+func (vm *VM) pushFrame(f *Frame) *object.Error {
+	if len(vm.frames) > vm.maxDepth {
+		return &object.Error{
+			Message: fmt.Sprintf(evaluator.DEPTH_LIMIT_EXCEEDED, vm.maxDepth),
+			Kind:    object.DEPTH_LIMIT_ERROR,
+		}
+	}
+	vm.frames = append(vm.frames, f)
+	return nil
+}
+
+func (vm *VM) popFrame() *Frame {
+	f := vm.frames[len(vm.frames)-1]
+	vm.frames = vm.frames[:len(vm.frames)-1]
+	return f
+}
+
+// グローバル変数を積む
+// まだ定義されていない場合は同じ名前の組み込み関数を探す
+func (vm *VM) pushGlobal(index int) *object.Error {
+	if global := vm.globals[index]; global != nil {
+		return vm.push(global)
+	}
+	return vm.pushBuiltin(vm.globalNames[index])
+}
+
+func (vm *VM) pushLocal(index int) *object.Error {
+	frame := vm.currentFrame()
+	local := vm.stack[frame.basePointer+index]
+	if local == nil {
+		return newError(evaluator.IDENTIFIER_NOT_FOUND_ERROR_PREFIX + frame.cl.Fn.LocalNames[index])
+	}
+	return vm.push(local)
+}
+
+// Cell に入った変数の値を積む
+func (vm *VM) pushCell(cell *object.Cell, name string) *object.Error {
+	if cell.Value == nil {
+		return newError(evaluator.IDENTIFIER_NOT_FOUND_ERROR_PREFIX + name)
+	}
+	return vm.push(cell.Value)
+}
+
+// 組み込み関数か、組み込みの値を積む
+func (vm *VM) pushBuiltin(name string) *object.Error {
+	if builtin, ok := vm.interp.LookupBuiltin(name); ok {
+		return vm.push(builtin)
+	}
+	if value, ok := vm.interp.LookupValue(name); ok {
+		return vm.push(value)
+	}
+	return newError(evaluator.IDENTIFIER_NOT_FOUND_ERROR_PREFIX + name)
+}
+
+func (vm *VM) pushClosure(constIndex int, numFree int) *object.Error {
+	function, ok := vm.constants[constIndex].(*object.CompiledFunction)
+	if !ok {
+		return newError("not a function: %+v", vm.constants[constIndex])
+	}
+
+	free := make([]object.Object, numFree)
+	for i := 0; i < numFree; i++ {
+		free[i] = vm.stack[vm.sp-numFree+i]
+	}
+	vm.sp = vm.sp - numFree
+
+	return vm.push(&object.Closure{Fn: function, Free: free})
+}
+
+func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
+	elements := make([]object.Object, endIndex-startIndex)
+	copy(elements, vm.stack[startIndex:endIndex])
+	return &object.Array{Elements: elements}
+}
+
+func (vm *VM) buildHash(startIndex, endIndex int) object.Object {
+	hash := object.NewHash((endIndex - startIndex) / 2)
+
+	for i := startIndex; i < endIndex; i += 2 {
+		key := vm.stack[i]
+		value := vm.stack[i+1]
+
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return newError(evaluator.UNUSABLE_HASH_KEY+"%s", key.Type())
+		}
+		hash.Set(hashKey.HashKey(), object.HashPair{Key: key, Value: value})
+	}
+
+	return hash
+}
+
+// スタックに積まれた関数と numArgs 個の引数で関数を呼び出す
+func (vm *VM) call(callee object.Object, numArgs int) *object.Error {
+	switch callee := callee.(type) {
+	case *object.Closure:
+		return vm.callClosure(callee, numArgs)
+	case *object.Builtin:
+		args := vm.stack[vm.sp-numArgs : vm.sp]
+		result := vm.callBuiltin(callee, args)
+		vm.sp = vm.sp - numArgs - 1
+		return vm.pushResult(result)
+	default:
+		return newError(evaluator.NOT_FUNCTION_ERROR+"%s", callee.Type())
+	}
+}
+
+func (vm *VM) callClosure(cl *object.Closure, numArgs int) *object.Error {
+	if numArgs < cl.Fn.NumParameters {
+		return newError(evaluator.WRONG_NUMBER_OF_ARGUMENTS, cl.Fn.NumParameters, numArgs)
+	}
+	// tree-walker と同じく余分な引数は無視する
+	vm.sp -= numArgs - cl.Fn.NumParameters
+
+	basePointer := vm.sp - cl.Fn.NumParameters
+	if err := vm.pushFrame(NewFrame(cl, basePointer)); err != nil {
+		return err
+	}
+
+	top := basePointer + cl.Fn.NumLocals
+	if top > len(vm.stack) {
+		if err := vm.growStack(top); err != nil {
+			return err
+		}
+	}
+	// 未代入のローカル変数を参照できないようにする
+	for i := vm.sp; i < top; i++ {
+		vm.stack[i] = nil
+	}
+	vm.sp = top
+
+	return nil
+}
+
+// 末尾位置の呼び出し
+// スクリプトの関数の場合は、実行中のフレームを呼び出す関数のフレームで置き換えて深さを増やさない
+// 実行中の関数で引数のエラーを捕捉している間は、捕捉先を残すため通常の呼び出しにする
+func (vm *VM) tailCall(numArgs int) *object.Error {
+	callee := vm.stack[vm.sp-1-numArgs]
+	cl, ok := callee.(*object.Closure)
+	if !ok || len(vm.frames) == 1 || vm.catching() || numArgs < cl.Fn.NumParameters {
+		return vm.call(callee, numArgs)
+	}
+
+	start := vm.currentFrame().basePointer - 1
+	copy(vm.stack[start:], vm.stack[vm.sp-1-numArgs:vm.sp])
+	vm.sp = start + 1 + numArgs
+	vm.popFrame()
+	return vm.callClosure(cl, numArgs)
+}
+
+// 実行中のフレームで登録された捕捉先があるか
+func (vm *VM) catching() bool {
+	return len(vm.handlers) > 0 && vm.handlers[len(vm.handlers)-1].frames == len(vm.frames)
+}
+
+func (vm *VM) callBuiltin(builtin *object.Builtin, args []object.Object) object.Object {
+	if builtin.HigherOrderFn != nil {
+		return builtin.HigherOrderFn(vm.callFunction, args...)
+	}
+	return builtin.Fn(args...)
+}
+
+// 組み込み関数からスクリプトの関数を呼び出す
+// 呼び出した関数が戻るまで、同じスタックの上で実行する
+func (vm *VM) callFunction(fn object.Object, args ...object.Object) object.Object {
+	switch fn := fn.(type) {
+	case *object.Builtin:
+		return vm.callBuiltin(fn, args)
+	case *object.Closure:
+		for _, o := range append([]object.Object{fn}, args...) {
+			if err := vm.push(o); err != nil {
+				return err
+			}
+		}
+		depth := len(vm.frames)
+		if err := vm.callClosure(fn, len(args)); err != nil {
+			return err
+		}
+		return vm.run(depth)
+	default:
+		return newError(evaluator.NOT_FUNCTION_ERROR+"%s", fn.Type())
+	}
+}
+
+// スタックに積まれたレシーバと numArgs 個の引数でメソッドを呼び出す
+func (vm *VM) callMethod(name string, numArgs int) *object.Error {
+	receiverIndex := vm.sp - 1 - numArgs
+	receiver := vm.stack[receiverIndex]
+
+	function, leading := vm.interp.LookupMethod(receiver, name)
+	if err, ok := function.(*object.Error); ok {
+		return err
+	}
+	if len(leading) == 0 {
+		// レシーバの位置に関数を置いて通常の呼び出しにする
+		vm.stack[receiverIndex] = function
+		return vm.call(function, numArgs)
+	}
+
+	// 組み込み関数はレシーバを第１引数として呼び出す
+	builtin := function.(*object.Builtin)
+	args := vm.stack[receiverIndex:vm.sp]
+	result := vm.callBuiltin(builtin, args)
+	vm.sp = receiverIndex
+	return vm.pushResult(result)
+}
+
+func newError(format string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...)}
+}
@@ -0,0 +1,414 @@
+package vm
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/oteto/gonkey/pkg/ast"
+	"github.com/oteto/gonkey/pkg/compiler"
+	"github.com/oteto/gonkey/pkg/evaluator"
+	"github.com/oteto/gonkey/pkg/object"
+	"github.com/oteto/gonkey/pkg/parser"
+	"github.com/oteto/gonkey/pkg/tokenizer"
+)
+
+// tree-walker と VM の結果が一致することを確認する
+func TestSameResultAsEvaluator(t *testing.T) {
+	tests := []string{
+		// 演算
+		"1 + 2 * 3 - 4 / 2",
+		"-(5 + 5)",
+		"!true; !!5",
+		"1 < 2 == true",
+		`"foo" + "bar"`,
+		`"foo" == "foo"`,
+		"[1, 2] == [1, 2]",
+
+		// 条件分岐
+		"if (1 > 2) { 10 }",
+		"if (1 < 2) { 10 } else { 20 }",
+		"if (false) { 10 } else { 20 }",
+		"if (if (false) { 1 }) { 1 } else { 2 }",
+
+		// 変数
+		"let a = 1; let b = a + 1; a + b",
+		"let a = 1; let a = a + 1; a",
+		"let x = 1;",
+
+		// return
+		"return 10; 9;",
+		"9; return 2 * 5; 9;",
+		"if (10 > 1) { if (10 > 1) { return 10; } return 1; }",
+
+		// 関数・クロージャ
+		"let add = fn(a, b) { a + b }; add(1, 2)",
+		"fn(x) { let y = x * 2; y }(3)",
+		"let f = fn(a) { a }; f(1, 2)",
+		"let newAdder = fn(a) { fn(b) { a + b } }; newAdder(2)(3)",
+		"let newAdder = fn(a, b) { let c = a + b; fn(d) { fn(e) { c + d + e } } }; newAdder(1, 2)(3)(4)",
+		"let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(15)",
+		"let wrapper = fn() { let countDown = fn(x) { if (x == 0) { 0 } else { countDown(x - 1) } }; countDown(3) }; wrapper()",
+		"let f = fn() { g() }; let g = fn() { 5 }; f()",
+		"let f = fn(x) { x }; f",
+
+		// データ構造
+		"[1, 2 + 3, 4][1]",
+		"[1, 2, 3][-1]",
+		"[1, 2, 3][5]",
+		`{"a": 1}`,
+		`{"b": 1, "a": 2, 3: 4}`,
+		`keys({"b": 1, "a": 2})`,
+		`merge({"a": 1}, {"b": 2}).len()`,
+		`{2: "b"}[2]`,
+		`{true: 3}[true]`,
+		`{"a": 1}["a"]`,
+		`{"a": 1}["b"]`,
+		"[1, 2, 3, 4][1:3]",
+		"[1, 2, 3, 4][:-1]",
+		`"hello"[1:]`,
+		`"hello"[:]`,
+		`{"a": 10}.a`,
+		`{"f": fn(x) { x * 2 }}.f(4)`,
+		"[1, 2].push(3).len()",
+		`"abc".len()`,
+
+		// パイプ
+		"[1, 2, 3] |> len",
+		"[1, 2] |> push(3)",
+		"let double = fn(x) { x * 2 }; 3 |> double |> double",
+
+		// 組み込み関数
+		"len([1, 2, 3])",
+		`first(["a", "b"])`,
+		"rest([1, 2, 3])",
+		"let len = fn(x) { 0 }; len([1])",
+		"map([1, 2, 3], fn(x) { x * 2 })",
+		"let n = 10; [1, 2].map(fn(x) { x + n })",
+		`map(["a", "bc"], len)`,
+		"map([[1], [2, 3]], fn(a) { map(a, fn(x) { -x }) })",
+		"let f = fn(x) { if (x > 2) { return x; } 0 }; filter([1, 2, 3, 4], f)",
+		"reduce(range(1, 5), fn(acc, x) { acc * x }, 1)",
+		"sort([1, 3, 2], fn(a, b) { a > b })",
+		"let r = map([1], fn(x) { x }); let s = 5; s + len(r)",
+		"map([1, true], fn(x) { -x })",
+		"sort([1, 2], fn(a, b) { 1 })",
+		`int("42") + int(true)`,
+		`str([1, "a"])`,
+		`type({})`,
+		`is_error(int("x"))`,
+		`is_error(int("1"))`,
+		`[is_error(1 + true), 5]`,
+		`let f = fn(x) { int(x) + 1 }; is_error(f("x"))`,
+		`let g = fn(x) { if (x) { return int("x"); } 1 }; is_error(g(true)) == is_error(g(false))`,
+		`is_error(map([1, "a"], fn(x) { -x }))`,
+		`let safe = fn(s) { if (is_error(int(s))) { 0 } else { int(s) } }; safe("x") + safe("2")`,
+		`let is_error = fn(x) { "shadowed" }; is_error(1)`,
+		`"x" |> is_error`,
+		`int("x").is_error()`,
+		`int("1").is_error()`,
+		`int("x") |> is_error()`,
+		`int("x") |> is_error`,
+		`let check = is_error; check(int("x"))`,
+		`int("x").len()`,
+		`int("x") |> len()`,
+		`{"is_error": fn(x) { x }}.is_error(int("x"))`,
+		`let f = fn(x) { int(x) }; [f("x").is_error(), f("x") |> is_error()]`,
+		`[math.abs(-1), math.min(3, 1, 2), math.max([4, 9]), math.pow(3, 4), math.sqrt(99)]`,
+		`[math.floor(-7, 2), math.ceil(-7, 2), math.clamp(15, 0, 10)]`,
+		`math.pow(2, 63)`,
+		`let m = math; m["abs"](-5)`,
+		`let math = 1; math`,
+		`math.random(3, 4)`,
+		`json_stringify({"b": [1, true], "a": "x"}, 2)`,
+		`let h = {"z": [1, {"y": "q"}], "a": true}; json_stringify(json_parse(json_stringify(h)))`,
+		`json_stringify([len])`,
+		`[match("^\d+$", "123"), find_all("\d+", "a1 b22"), capture("(a)|(b)", "b")]`,
+		`let re = regex("(\w+)=(\d+)"); [re, re.capture("k=1"), re.match("x")]`,
+		`replace_all("\d+", "a1b22", fn(m) { str(int(m) * 2) })`,
+		`replace_all("\d+", "a1b22", fn(m) { 1 })`,
+		`regex("(")`,
+		`exists("a")`,
+		`[args, len(args)]`,
+		`let f = fn(args) { args }; f(1)`,
+		`let args = 2; args`,
+		`[duration("1h30m"), format_time(86400000), parse_time("1970-01-02T00:00:00Z")]`,
+		`format_duration(duration("90s") + 5)`,
+		`sleep(-1)`,
+		`int("x")`,
+
+		// エラー
+		"5 + true",
+		"5 + true; 5;",
+		"-true",
+		"1 / 0",
+		"let x = 0; 10 / x",
+		`"a" - "b"`,
+		"let f = fn(a, b) { a }; f(1)",
+		"1(2)",
+		`{fn(x) { x }: 1}`,
+		`{"a": 1}[fn(x) { x }]`,
+		"1[0]",
+		"1.foo",
+		"[1].undefined()",
+		`len(1)`,
+		"[1, 2][\"a\":]",
+		"let f = fn() { return 5 + true; 1 }; f(); 2",
+
+		// 後で定義される変数と末尾呼び出し
+		"let f = fn() { let g = fn() { x }; let x = 5; g() }; f()",
+		"let f = fn() { let g = fn() { x }; let r = g(); let x = 5; r }; f()",
+		"let x = 1; let f = fn() { let x = x + 1; x }; f()",
+		"let f = fn() { let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } }; let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } }; [even(10), odd(7), even(100001)] }; f()",
+		"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(9999)",
+		"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(10000)",
+		"let loop = fn(n) { if (n == 0) { 0 } else { loop(n - 1) } }; loop(1000000)",
+		"let loop = fn(n) { if (n == 0) { return 0 } return loop(n - 1) }; loop(1000000)",
+		"let g = fn() { let loop = fn(n, acc) { if (n == 0) { acc } else { loop(n - 1, acc + n) } }; loop(1000000, 0) }; g()",
+		"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; is_error(f(10000))",
+		"let f = fn(n) { is_error(n) }; let g = fn(n) { f(n) }; g(1)",
+	}
+
+	for _, input := range tests {
+		program := parse(input)
+
+		expected := evaluator.Eval(program, object.NewEnvironment())
+		actual := run(t, program)
+
+		if inspect(actual) != inspect(expected) {
+			t.Errorf("%s: result wrong.\nevaluator=%s\nvm       =%s", input, inspect(expected), inspect(actual))
+		}
+	}
+}
+
+// tree-walker では値を持たないブロックは Go の nil になるが、VM では null になる
+// tree-walker が実行前に報告する未定義の変数は、コンパイルエラーにする
+func TestUndefinedIdentifier(t *testing.T) {
+	tests := []string{
+		"foobar",
+		"let f = fn() { y }; 1",
+		"let f = fn() { x }; f()",
+		"is_error(foo)",
+		`puts("run"); foo`,
+		"y; let y = 1",
+		"let f = fn() { let g = fn() { z }; g() }; f()",
+		"let f = fn() { x; let x = 1; x }; f()",
+	}
+
+	for _, input := range tests {
+		program := parse(input)
+
+		expected, ok := evaluator.Eval(program, object.NewEnvironment()).(*object.Error)
+		if !ok {
+			t.Fatalf("%s: evaluator did not return an error", input)
+		}
+		err := compiler.New().Compile(program)
+		if err == nil || err.Error() != expected.Message {
+			t.Errorf("%s: wrong compiler error. want=%q, got=%v", input, expected.Message, err)
+		}
+	}
+
+	// 組み込み関数・組み込みの値と、後で定義されるグローバル変数は参照できる
+	for _, input := range []string{"len", "math", "args", "let f = fn() { x }; let x = 1; f()"} {
+		if err := compiler.New().Compile(parse(input)); err != nil {
+			t.Errorf("%s: compiler error: %s", input, err)
+		}
+	}
+}
+
+func TestEmptyBlockValue(t *testing.T) {
+	tests := []string{
+		"if (true) { let a = 1; }",
+		"fn() { }()",
+		"fn() { let a = 1; }()",
+	}
+
+	for _, input := range tests {
+		result := run(t, parse(input))
+		if result != evaluator.NULL {
+			t.Errorf("%s: object is not NULL. got=%T (%+v)", input, result, result)
+		}
+	}
+}
+
+func TestPuts(t *testing.T) {
+	var buf bytes.Buffer
+	interp := evaluator.New(evaluator.WithStdout(&buf))
+
+	comp := compiler.New(compiler.WithBuiltins(interp))
+	if err := comp.Compile(parse(`puts("hello", 1)`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	NewWithInterpreter(comp.Bytecode(), make([]object.Object, GlobalsSize), interp).Run()
+
+	if buf.String() != "hello\n1\n" {
+		t.Errorf("output wrong. got=%q", buf.String())
+	}
+}
+
+func TestRegisteredBuiltin(t *testing.T) {
+	interp := evaluator.New()
+	interp.Register("answer", func(args ...object.Object) object.Object {
+		return &object.Integer{Value: 42}
+	})
+
+	if err := compiler.New().Compile(parse("answer()")); err == nil {
+		t.Fatalf("builtin of another interpreter was resolved")
+	}
+	comp := compiler.New(compiler.WithBuiltins(interp))
+	if err := comp.Compile(parse("answer()")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	result := NewWithInterpreter(comp.Bytecode(), make([]object.Object, GlobalsSize), interp).Run()
+	if inspect(result) != "42" {
+		t.Errorf("result wrong. got=%q", inspect(result))
+	}
+}
+
+func TestDepthLimit(t *testing.T) {
+	program := parse("let f = fn(x) { 1 + f(x + 1) }; f(0)")
+	result := run(t, program)
+
+	err, ok := result.(*object.Error)
+	if !ok {
+		t.Fatalf("object is not Error. got=%T (%+v)", result, result)
+	}
+	if err.Kind != object.DEPTH_LIMIT_ERROR {
+		t.Errorf("wrong error kind. got=%q", err.Kind)
+	}
+	if err.Message != fmt.Sprintf(evaluator.DEPTH_LIMIT_EXCEEDED, evaluator.DefaultMaxDepth) {
+		t.Errorf("wrong error message. got=%q", err.Message)
+	}
+}
+
+// オペランドの上限ちょうどのプログラムも、tree-walker と同じ結果になる
+func TestOperandBoundaries(t *testing.T) {
+	locals := make([]string, 256)
+	for i := range locals {
+		locals[i] = fmt.Sprintf("let %s = %d;", letterName(i), i)
+	}
+	params := make([]string, 255)
+	args := make([]string, 255)
+	for i := range params {
+		params[i] = letterName(i)
+		args[i] = fmt.Sprint(i)
+	}
+	constants := make([]string, 65536)
+	for i := range constants {
+		constants[i] = fmt.Sprintf("%d;", i)
+	}
+
+	tests := []string{
+		fmt.Sprintf("fn() { %s %s + %s }()", strings.Join(locals, " "), letterName(0), letterName(255)),
+		fmt.Sprintf("fn(%s) { %s + %s }(%s)", strings.Join(params, ", "), params[0], params[254], strings.Join(args, ", ")),
+		strings.Join(constants, " "),
+		"if (true) { " + strings.Repeat("true; ", 32762) + "1 }",
+	}
+
+	for _, input := range tests {
+		program := parse(input)
+
+		expected := evaluator.Eval(program, object.NewEnvironment())
+		actual := run(t, program)
+
+		if inspect(actual) != inspect(expected) {
+			t.Errorf("%.40s...: result wrong.\nevaluator=%s\nvm       =%s", input, inspect(expected), inspect(actual))
+		}
+	}
+}
+
+// 識別子に数字は使えないので、i を英小文字だけの名前にする
+func letterName(i int) string {
+	name := ""
+	for {
+		name = string(rune('a'+i%26)) + name
+		i /= 26
+		if i == 0 {
+			return "v" + name
+		}
+	}
+}
+
+func TestGlobalsStore(t *testing.T) {
+	globals := make([]object.Object, GlobalsSize)
+	symbolTable := compiler.NewSymbolTable()
+	constants := []object.Object{}
+
+	inputs := []struct {
+		input  string
+		expect string
+	}{
+		{"let a = 5;", ""},
+		{"let add = fn(x) { x + a };", ""},
+		{"add(10)", "15"},
+	}
+
+	for _, tt := range inputs {
+		comp := compiler.NewWithState(symbolTable, constants)
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		bytecode := comp.Bytecode()
+		constants = bytecode.Constants
+
+		result := NewWithGlobalsStore(bytecode, globals).Run()
+		if inspect(result) != tt.expect {
+			t.Errorf("%s: result wrong. want=%q, got=%q", tt.input, tt.expect, inspect(result))
+		}
+	}
+}
+
+const fibonacciInput = `
+let fibonacci = fn(x) {
+	if (x < 2) {
+		x
+	} else {
+		fibonacci(x - 1) + fibonacci(x - 2)
+	}
+};
+fibonacci(20);
+`
+
+func BenchmarkFibonacciEvaluator(b *testing.B) {
+	program := parse(fibonacciInput)
+	for i := 0; i < b.N; i++ {
+		evaluator.Eval(program, object.NewEnvironment())
+	}
+}
+
+func BenchmarkFibonacciVM(b *testing.B) {
+	program := parse(fibonacciInput)
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		b.Fatalf("compiler error: %s", err)
+	}
+	bytecode := comp.Bytecode()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		New(bytecode).Run()
+	}
+}
+
+func parse(input string) *ast.Program {
+	p := parser.New(tokenizer.New(input))
+	return p.ParseProgram()
+}
+
+func run(t *testing.T, program *ast.Program) object.Object {
+	t.Helper()
+
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return New(comp.Bytecode()).Run()
+}
+
+func inspect(obj object.Object) string {
+	if obj == nil {
+		return ""
+	}
+	return obj.Inspect()
+}
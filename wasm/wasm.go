@@ -5,10 +5,13 @@ import (
 	"encoding/json"
 	"syscall/js"
 
+	"github.com/oteto/gonkey/pkg/compiler"
 	"github.com/oteto/gonkey/pkg/evaluator"
 	"github.com/oteto/gonkey/pkg/object"
+	"github.com/oteto/gonkey/pkg/optimizer"
 	"github.com/oteto/gonkey/pkg/parser"
 	"github.com/oteto/gonkey/pkg/tokenizer"
+	"github.com/oteto/gonkey/pkg/vm"
 )
 
 func tokenize(this js.Value, args []js.Value) interface{} {
@@ -42,14 +45,24 @@ func parse(this js.Value, args []js.Value) interface{} {
 	return buf.String()
 }
 
+// 第２引数に "vm" を渡すとバイトコード VM で実行する
 func eval(this js.Value, args []js.Value) interface{} {
 	input := args[0].String()
 	p := parser.New(tokenizer.New(input))
-	program := p.ParseProgram()
-	env := object.NewEnvironment()
+	program := optimizer.Optimize(p.ParseProgram())
 	var buf bytes.Buffer
-	evaluator.SetWriter(&buf)
-	evaluator.Eval(program, env)
+	interp := evaluator.New(evaluator.WithStdout(&buf), evaluator.WithStderr(&buf))
+
+	if len(args) > 1 && args[1].String() == "vm" {
+		comp := compiler.New(compiler.WithBuiltins(interp))
+		if err := comp.Compile(program); err != nil {
+			return "compilation failed: " + err.Error()
+		}
+		vm.NewWithInterpreter(comp.Bytecode(), make([]object.Object, vm.GlobalsSize), interp).Run()
+		return buf.String()
+	}
+
+	interp.Eval(program)
 
 	return buf.String()
 }